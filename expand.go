@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// expandPaths replaces each directory in paths with the regular files
+// beneath it when recursive is set, keeping only those whose base name
+// matches glob, if given, and skipping the tag map's own files when own
+// is given. Other paths, including ones that don't exist, are passed
+// through for the caller to report.
+func expandPaths(paths []string, recursive bool, glob string, own *tagMapFiles) ([]string, error) {
+	if glob != "" {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	result := []string{}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil || !recursive || !fi.IsDir() {
+			result = append(result, p)
+			continue
+		}
+
+		err = filepath.Walk(p, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				if file != p && fi.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			if glob != "" {
+				if ok, _ := filepath.Match(glob, fi.Name()); !ok {
+					return nil
+				}
+			}
+			if own != nil {
+				p, err := resolvePath(file)
+				if err != nil {
+					return err
+				}
+				if own.match(p) {
+					return nil
+				}
+			}
+
+			result = append(result, file)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
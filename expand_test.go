@@ -0,0 +1,74 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("expandPaths", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = filepath.EvalSymlinks(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, name := range []string{"a.md", "b.txt", "sub/c.md", ".git/d.md", ".ftag", ".ftag.lock", ".ftags/e.md"} {
+			p := filepath.Join(dir, filepath.FromSlash(name))
+			Expect(os.MkdirAll(filepath.Dir(p), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(p, nil, 0644)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	rel := func(files []string) []string {
+		result := make([]string, len(files))
+		for i, f := range files {
+			r, err := filepath.Rel(dir, f)
+			Expect(err).ToNot(HaveOccurred())
+			result[i] = filepath.ToSlash(r)
+		}
+		return result
+	}
+
+	It("should replace directories with the files beneath them", func() {
+		files, err := expandPaths([]string{dir}, true, "", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel(files)).To(ConsistOf(".ftag", ".ftag.lock", ".ftags/e.md", "a.md", "b.txt", "sub/c.md"))
+	})
+
+	It("should keep the files whose names match the glob", func() {
+		files, err := expandPaths([]string{dir}, true, "*.md", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel(files)).To(ConsistOf(".ftags/e.md", "a.md", "sub/c.md"))
+	})
+
+	It("should skip the tag map's own files", func() {
+		own := &tagMapFiles{dir: dir, mapName: ".ftag"}
+		files, err := expandPaths([]string{dir}, true, "", own)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rel(files)).To(ConsistOf(".ftags/e.md", "a.md", "b.txt", "sub/c.md"))
+	})
+
+	It("should pass other paths through", func() {
+		paths := []string{filepath.Join(dir, "sub"), filepath.Join(dir, "nope")}
+		files, err := expandPaths(paths, false, "*.md", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(Equal(paths))
+	})
+
+	It("should reject an invalid glob", func() {
+		_, err := expandPaths([]string{dir}, true, "[", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+})
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileFilter narrows the results of find by where the files are and what
+// os.Stat reports about them.
+type fileFilter struct {
+	under []string // absolute directories
+	glob  string
+	newer time.Time
+	size  *sizeFilter
+	kind  string
+}
+
+type sizeFilter struct {
+	cmp   int // 1 for larger than, -1 for smaller than, 0 for exactly
+	bytes int64
+}
+
+const (
+	typeFile = "f"
+	typeDir  = "d"
+)
+
+func getFileFilter(c *cli.Context) (*fileFilter, error) {
+	f := &fileFilter{
+		glob: c.String(optPathLong),
+		kind: c.String(optTypeLong),
+	}
+
+	for _, dir := range c.StringSlice(optUnderLong) {
+		p, err := resolvePath(dir)
+		if err != nil {
+			return nil, err
+		}
+		f.under = append(f.under, p)
+	}
+
+	if f.glob != "" {
+		if _, err := filepath.Match(f.glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid path pattern %q: %s", f.glob, err)
+		}
+	}
+
+	if s := c.String(optNewerLong); s != "" {
+		t, err := parseNewer(s, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		f.newer = t
+	}
+
+	if s := c.String(optSizeLong); s != "" {
+		size, err := parseSize(s)
+		if err != nil {
+			return nil, err
+		}
+		f.size = size
+	}
+
+	switch f.kind {
+	case "", typeFile, typeDir:
+	default:
+		return nil, fmt.Errorf("invalid type %q, expected %s or %s", f.kind, typeFile, typeDir)
+	}
+
+	return f, nil
+}
+
+var newerLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseNewer parses a local date and time, or a duration before now such
+// as "36h" or "7d".
+func parseNewer(s string, now time.Time) (time.Time, error) {
+	for _, layout := range newerLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a date like 2006-01-02, a time like 2006-01-02T15:04:05 or a duration like 36h or 7d", s)
+}
+
+var sizeUnits = map[byte]int64{
+	'k': 1 << 10,
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+}
+
+// parseSize parses a size in bytes, or with a k, M, G or T suffix, that
+// matches larger sizes when prefixed with '+' and smaller ones with '-'.
+func parseSize(s string) (*sizeFilter, error) {
+	f := &sizeFilter{}
+	n := s
+	switch {
+	case strings.HasPrefix(n, "+"):
+		f.cmp, n = 1, n[1:]
+	case strings.HasPrefix(n, "-"):
+		f.cmp, n = -1, n[1:]
+	}
+
+	unit := int64(1)
+	if len(n) > 0 {
+		if u, ok := sizeUnits[n[len(n)-1]]; ok {
+			unit, n = u, n[:len(n)-1]
+		}
+	}
+
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v < 0 {
+		return nil, fmt.Errorf("invalid size %q, expected a number of bytes with an optional k, M, G or T suffix and + or - prefix, e.g. +10M", s)
+	}
+	f.bytes = v * unit
+	return f, nil
+}
+
+func (sf *sizeFilter) match(size int64) bool {
+	switch sf.cmp {
+	case 1:
+		return size > sf.bytes
+	case -1:
+		return size < sf.bytes
+	}
+	return size == sf.bytes
+}
+
+func (f *fileFilter) needsStat() bool {
+	return !f.newer.IsZero() || f.size != nil || f.kind != ""
+}
+
+// match reports whether a file passes the filter. Files that no longer
+// exist only pass filters that don't look at their metadata.
+func (f *fileFilter) match(file string) (bool, error) {
+	if len(f.under) > 0 {
+		p, err := resolvePath(file)
+		if err != nil {
+			return false, err
+		}
+		under := false
+		for _, dir := range f.under {
+			if rel, err := filepath.Rel(dir, p); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				under = true
+				break
+			}
+		}
+		if !under {
+			return false, nil
+		}
+	}
+
+	if f.glob != "" {
+		// A pattern without a separator matches the base name, as in
+		// .gitignore.
+		name := file
+		if !strings.ContainsAny(f.glob, "/"+string(filepath.Separator)) {
+			name = filepath.Base(file)
+		}
+		if ok, _ := filepath.Match(filepath.FromSlash(f.glob), name); !ok {
+			return false, nil
+		}
+	}
+
+	if !f.needsStat() {
+		return true, nil
+	}
+
+	fi, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !f.newer.IsZero() && !fi.ModTime().After(f.newer) {
+		return false, nil
+	}
+	if f.size != nil && (fi.IsDir() || !f.size.match(fi.Size())) {
+		return false, nil
+	}
+	switch f.kind {
+	case typeFile:
+		return fi.Mode().IsRegular(), nil
+	case typeDir:
+		return fi.IsDir(), nil
+	}
+	return true, nil
+}
+
+// apply keeps the files that pass the filter.
+func (f *fileFilter) apply(files []string) ([]string, error) {
+	result := []string{}
+	for _, file := range files {
+		ok, err := f.match(file)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = append(result, file)
+		}
+	}
+	return result, nil
+}
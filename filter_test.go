@@ -0,0 +1,108 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("fileFilter", func() {
+
+	Describe("parseNewer", func() {
+
+		now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+		DescribeTable("should parse times and durations",
+			func(s string, expected time.Time) {
+				t, err := parseNewer(s, now)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(t.Equal(expected)).To(BeTrue(), "got %s", t)
+			},
+			Entry("days", "7d", now.AddDate(0, 0, -7)),
+			Entry("hours", "36h", now.Add(-36*time.Hour)),
+			Entry("RFC3339", "2026-01-02T03:04:05Z", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)),
+			Entry("a local date", "2026-01-02", time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)),
+			Entry("a local time", "2026-01-02 15:04", time.Date(2026, 1, 2, 15, 4, 0, 0, time.Local)),
+		)
+
+		DescribeTable("should reject invalid input",
+			func(s string) {
+				_, err := parseNewer(s, now)
+				Expect(err).To(MatchError(HavePrefix("invalid time")))
+			},
+			Entry("a word", "yesterday"),
+			Entry("negative days", "-1d"),
+			Entry("a negative duration", "-2h"),
+			Entry("an empty string", ""),
+		)
+
+	})
+
+	Describe("parseSize", func() {
+
+		DescribeTable("should parse sizes",
+			func(s string, cmp int, bytes int64) {
+				Expect(parseSize(s)).To(Equal(&sizeFilter{cmp: cmp, bytes: bytes}))
+			},
+			Entry("larger than", "+10M", 1, int64(10<<20)),
+			Entry("smaller than", "-1k", -1, int64(1<<10)),
+			Entry("exactly", "512", 0, int64(512)),
+			Entry("an upper case unit", "2K", 0, int64(2<<10)),
+		)
+
+		DescribeTable("should reject invalid input",
+			func(s string) {
+				_, err := parseSize(s)
+				Expect(err).To(MatchError(HavePrefix("invalid size")))
+			},
+			Entry("an unknown unit", "10X"),
+			Entry("a missing number", "+M"),
+			Entry("two signs", "--5"),
+			Entry("a fraction", "1.5G"),
+			Entry("an empty string", ""),
+		)
+
+		It("should compare sizes", func() {
+			Expect((&sizeFilter{cmp: 1, bytes: 10}).match(11)).To(BeTrue())
+			Expect((&sizeFilter{cmp: 1, bytes: 10}).match(10)).To(BeFalse())
+			Expect((&sizeFilter{cmp: -1, bytes: 10}).match(9)).To(BeTrue())
+			Expect((&sizeFilter{cmp: 0, bytes: 10}).match(10)).To(BeTrue())
+		})
+
+	})
+
+	Describe("under", func() {
+
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ftag-test")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		DescribeTable("should match files in the directory or below it",
+			func(file string, expected bool) {
+				f := &fileFilter{under: []string{filepath.Join(dir, "docs")}}
+				Expect(f.match(filepath.Join(dir, filepath.FromSlash(file)))).To(Equal(expected))
+			},
+			Entry("a file in it", "docs/a.md", true),
+			Entry("a file in a subdirectory", "docs/sub/a.md", true),
+			Entry("the directory itself", "docs", true),
+			Entry("a name starting with ..", "docs/..a.md", true),
+			Entry("a path leaving it with ..", "docs/../a.md", false),
+			Entry("its parent", "docs/..", false),
+			Entry("a sibling sharing its prefix", "docs2/a.md", false),
+		)
+
+	})
+
+})
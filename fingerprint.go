@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/troykinsella/ftag/tagmap"
+	"io"
+	"os"
+)
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fingerprint(path string, fi os.FileInfo) (tagmap.Fingerprint, error) {
+	hash, err := hashFile(path)
+	if err != nil {
+		return tagmap.Fingerprint{}, err
+	}
+
+	return tagmap.Fingerprint{
+		Hash:    hash,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UTC(),
+	}, nil
+}
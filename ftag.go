@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/troykinsella/ftag/query"
 	"github.com/troykinsella/ftag/tagmap"
 	"os"
 	"sort"
@@ -9,99 +10,297 @@ import (
 
 type FTag struct {
 	tagMapStore tagmap.Store
+	root        string
+	history     *tagmap.History
 
-	tagMap *tagmap.TM
+	// onSkip, if set, is told of each file that a walk of the root
+	// directory couldn't read and left out.
+	onSkip func(err error)
+
+	tx tagmap.Tx
 }
 
-func New(tagMapStore tagmap.Store) *FTag {
+func New(tagMapStore tagmap.Store, root string) *FTag {
 	if tagMapStore == nil {
 		panic("tagMapStore required")
 	}
+	if root == "" {
+		panic("root required")
+	}
 
 	return &FTag{
 		tagMapStore: tagMapStore,
+		root:        root,
 	}
 }
 
-func (ft *FTag) LoadTagMap() error {
-	var err error
-	ft.tagMap, err = ft.tagMapStore.Load()
+// View runs fn with read access to the tag map.
+func (ft *FTag) View(fn func() error) error {
+	return ft.tagMapStore.View(ft.withTx(fn))
+}
+
+// Update runs fn with write access to the tag map; its changes are stored
+// together only if it succeeds, and then recorded in the history as
+// command.
+func (ft *FTag) Update(command string, fn func() error) error {
+	if ft.history == nil {
+		return ft.tagMapStore.Update(ft.withTx(fn))
+	}
+
+	return ft.history.WithLock(func() error {
+		var changes []tagmap.FileChange
+		err := ft.tagMapStore.Update(func(tx tagmap.Tx) error {
+			rtx := tagmap.NewRecordingTx(tx)
+			err := ft.withTx(fn)(rtx)
+			if err != nil {
+				return err
+			}
+
+			changes, err = rtx.Changes()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return ft.history.Record(command, changes)
+	})
+}
+
+func (ft *FTag) skip(err error) {
+	if ft.onSkip != nil {
+		ft.onSkip(err)
+	}
+}
+
+func (ft *FTag) withTx(fn func() error) func(tx tagmap.Tx) error {
+	return func(tx tagmap.Tx) error {
+		ft.tx = tx
+		defer func() {
+			ft.tx = nil
+		}()
+
+		return fn()
+	}
+}
+
+func (ft *FTag) Add(file string, tags ...string) error {
+
+	// Check every tag and attribute, and fingerprint the file, before
+	// changing anything, so that a failure leaves the file as it was.
+	var clean []string
+	var keys []string
+	attrs := make(tagmap.Attrs)
+	for _, tag := range tags {
+		if tagmap.IsAttr(tag) {
+			k, v, err := tagmap.ParseAttr(tag)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+			attrs[k] = v
+			continue
+		}
+
+		c := tagmap.CleanTag(tag)
+		if c == "" {
+			return fmt.Errorf("invalid tag: %q", tag)
+		}
+		clean = append(clean, c)
+	}
+
+	key, err := ft.fileKey(file)
 	if err != nil {
 		return err
 	}
 
-	return err
-}
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	var fp *tagmap.Fingerprint
+	if fi.Mode().IsRegular() {
+		f, err := fingerprint(file, fi)
+		if err != nil {
+			return err
+		}
+		fp = &f
+	}
 
-func (ft *FTag) StoreTagMap() error {
-	err := ft.tagMapStore.Put(ft.tagMap)
+	err = ft.tx.Add(key, clean...)
 	if err != nil {
 		return err
 	}
-	ft.tagMap = nil
+	for _, k := range keys {
+		err = ft.tx.SetAttr(key, k, attrs[k])
+		if err != nil {
+			return err
+		}
+	}
+
+	if fp != nil {
+		return ft.tx.SetFingerprint(key, *fp)
+	}
 
 	return nil
 }
 
-func (ft *FTag) Add(file string, tags ...string) error {
-
-	if _, err := os.Stat(file); err != nil {
+func (ft *FTag) Clear(files ...string) error {
+	keys, err := ft.fileKeys(files)
+	if err != nil {
 		return err
 	}
 
-	for _, tag := range tags {
-		ft.tagMap.Add(file, tag)
+	for _, key := range keys {
+		err = ft.tx.Clear(key)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (ft *FTag) Clear(files ...string) {
-	for _, file := range files {
-		ft.tagMap.Clear(file)
+// Find returns the files matching a tag expression, whose tags are all
+// regular expressions if isRegexp is set.
+func (ft *FTag) Find(expr string, isRegexp bool) ([]string, error) {
+	parse := query.Parse
+	if isRegexp {
+		parse = query.ParseRegexp
+	}
+	e, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := query.Eval(e, ft.tx)
+	if err != nil {
+		return nil, err
 	}
+
+	files := ft.displayPaths(keys)
+	sort.Strings(files)
+	return files, nil
 }
 
-func (ft *FTag) Find(tags ...string) []string {
+func (ft *FTag) Remove(file string, tags ...string) error {
+	key, err := ft.fileKey(file)
+	if err != nil {
+		return err
+	}
 
-	// FilesFor files having any of the given tags
-	all_files := ft.tagMap.FilesFor(tags...)
+	for _, tag := range tags {
+		if tagmap.IsAttr(tag) {
+			err := ft.removeAttr(key, tag)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = ft.tx.Remove(key, tagmap.CleanTag(tag))
+		if err != nil {
+			return err
+		}
+	}
 
-	result := make([]string, 0, len(all_files))
+	return nil
+}
 
-	// Retain files that have all the given tags
-file_loop:
-	for _, file := range all_files {
+// ExpandTags replaces each glob pattern in tags, or each tag if isRegexp
+// is set, with the tags in the tag map that it matches, failing for one
+// that matches none. Attributes are kept as they are.
+func (ft *FTag) ExpandTags(tags []string, isRegexp bool) ([]string, error) {
+	var all []string
+	result := []string{}
+	for _, tag := range tags {
+		if tagmap.IsAttr(tag) || (!isRegexp && !query.IsGlob(tag)) {
+			result = append(result, tag)
+			continue
+		}
 
-		for _, tag := range tags {
-			if !ft.tagMap.FileToTag.HasValue(file, tag) {
-				continue file_loop
+		p, err := query.NewPattern(tag, isRegexp)
+		if err != nil {
+			return nil, err
+		}
+		if all == nil {
+			all, err = ft.tx.Tags()
+			if err != nil {
+				return nil, err
 			}
 		}
+		matched, err := p.MatchTags(all)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, matched...)
+	}
+	return result, nil
+}
+
+// removeAttr removes "key=value" from a file only when it has that value,
+// and "key=" regardless of the value.
+func (ft *FTag) removeAttr(file, attr string) error {
+	k, v, err := tagmap.ParseAttr(attr)
+	if err != nil {
+		return err
+	}
 
-		result = append(result, file)
+	attrs, err := ft.tx.Attrs(file)
+	if err != nil {
+		return err
 	}
 
-	return result
+	if current, ok := attrs[k]; ok && (v == "" || current == v) {
+		return ft.tx.UnsetAttr(file, k)
+	}
+	return nil
 }
 
-func (ft *FTag) Remove(file string, tags ...string) {
+func (ft *FTag) RemoveRecursive(file string, tags ...string) error {
+	key, err := ft.fileKey(file)
+	if err != nil {
+		return err
+	}
+
 	for _, tag := range tags {
-		ft.tagMap.Remove(file, tag)
+		if tagmap.IsAttr(tag) {
+			err := ft.removeAttr(key, tag)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = ft.removeUnder(key, tag)
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func (ft *FTag) List(files []string) []string {
-	if len(files) == 0 {
-		files = ft.tagMap.ListFiles()
+// removeUnder removes tag and its descendant tags from a file.
+func (ft *FTag) removeUnder(file, tag string) error {
+	_, err := tagmap.RemoveUnder(ft.tx, file, tagmap.CleanTag(tag))
+	return err
+}
+
+func (ft *FTag) List(files []string) ([]string, error) {
+	keys, err := ft.fileKeys(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return ft.tx.Tags()
 	}
 
 	tagSet := make(map[string]bool)
 
-	for _, file := range files {
-		tags, ok := ft.tagMap.FileToTag[file]
-		if !ok {
-			continue
+	for _, key := range keys {
+		tags, err := ft.tx.TagsFor(key)
+		if err != nil {
+			return nil, err
 		}
 		for _, tag := range tags {
 			tagSet[tag] = true
@@ -117,39 +316,171 @@ func (ft *FTag) List(files []string) []string {
 	}
 
 	sort.Strings(tagList)
-	return tagList
+	return tagList, nil
 }
 
-func (ft *FTag) Check() []error {
-	files := ft.tagMap.ListFiles()
+func (ft *FTag) ListAttrs(files []string) ([]string, error) {
+	keys, err := ft.fileKeys(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		keys, err = ft.tx.Files()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	result := make([]error, 0)
+	attrSet := make(map[string]bool)
+	for _, key := range keys {
+		attrs, err := ft.tx.Attrs(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, attr := range attrs.Strings() {
+			attrSet[attr] = true
+		}
+	}
+
+	attrList := make([]string, 0, len(attrSet))
+	for attr := range attrSet {
+		attrList = append(attrList, attr)
+	}
+
+	sort.Strings(attrList)
+	return attrList, nil
+}
+
+// TagsFor lists the tags of a file.
+func (ft *FTag) TagsFor(file string) ([]string, error) {
+	key, err := ft.fileKey(file)
+	if err != nil {
+		return nil, err
+	}
+	return ft.tx.TagsFor(key)
+}
+
+// CountTags counts the files having each tag, and each key=value attribute
+// when attrs is set, among the given files or all files.
+func (ft *FTag) CountTags(files []string, attrs bool) (map[string]int, error) {
+	keys, err := ft.fileKeys(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		keys, err = ft.tx.Files()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, key := range keys {
+		tags, err := ft.tx.TagsFor(key)
+		if err != nil {
+			return nil, err
+		}
+		if attrs {
+			a, err := ft.tx.Attrs(key)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, a.Strings()...)
+		}
+		for _, tag := range tags {
+			counts[tag]++
+		}
+	}
+
+	return counts, nil
+}
+
+func (ft *FTag) Check() ([]error, error) {
+	files, err := ft.tx.Files()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	result, err := ft.tx.Verify()
+	if err != nil {
+		return nil, err
+	}
 
 	for _, file := range files {
-		if _, err := os.Stat(file); err != nil {
+		if _, err := os.Stat(ft.displayPath(file)); err != nil {
 			result = append(result, err)
 		}
 	}
 
-	return result
+	return result, nil
+}
+
+// Fix repairs the tag map index, returning the problems that were fixed.
+func (ft *FTag) Fix() ([]error, error) {
+	return ft.tx.Rebuild()
 }
 
 func (ft *FTag) Move(from, to string) error {
+	fromKey, err := ft.fileKey(from)
+	if err != nil {
+		return err
+	}
+	toKey, err := ft.fileKey(to)
+	if err != nil {
+		return err
+	}
 
-	tags, ok := ft.tagMap.FileToTag[from]
-	if !ok {
+	moved, err := ft.tx.Move(fromKey, toKey)
+	if err != nil {
+		return err
+	}
+	if !moved {
 		return fmt.Errorf("tag mapping for file not found: %s", from)
 	}
 
-	// Re-map FileToTag
-	delete(ft.tagMap.FileToTag, from)
-	ft.tagMap.FileToTag.AddUnique(to, tags...)
+	return nil
+}
 
-	// Re-map TagToFile
-	for _, tag := range tags {
-		ft.tagMap.TagToFile.RemoveFirst(tag, from)
-		ft.tagMap.TagToFile.AddUnique(tag, to)
+// RenameTag renames a tag on every file, returning the files changed.
+func (ft *FTag) RenameTag(from, to string, merge bool) ([]string, error) {
+	tags, err := cleanTags(from, to)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	keys, err := tagmap.RenameTag(ft.tx, tags[0], tags[1], merge)
+	if err != nil {
+		return nil, err
+	}
+	return ft.displayPaths(keys), nil
+}
+
+// MergeTags replaces tags with into on every file, returning the files
+// changed.
+func (ft *FTag) MergeTags(tags []string, into string) ([]string, error) {
+	clean, err := cleanTags(append(tags, into)...)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := tagmap.MergeTags(ft.tx, clean[:len(tags)], clean[len(tags)])
+	if err != nil {
+		return nil, err
+	}
+	return ft.displayPaths(keys), nil
+}
+
+func cleanTags(tags ...string) ([]string, error) {
+	clean := make([]string, len(tags))
+	for i, tag := range tags {
+		if tagmap.IsAttr(tag) {
+			return nil, fmt.Errorf("not a tag: %q", tag)
+		}
+		clean[i] = tagmap.CleanTag(tag)
+		if clean[i] == "" {
+			return nil, fmt.Errorf("invalid tag: %q", tag)
+		}
+	}
+	return clean, nil
 }
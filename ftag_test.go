@@ -0,0 +1,119 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+var _ = Describe("FTag", func() {
+
+	var dir string
+	var ftag *FTag
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = filepath.EvalSymlinks(dir)
+		Expect(err).ToNot(HaveOccurred())
+		ftag = New(tagmap.NewJSONFileStore(filepath.Join(dir, ".ftag")), dir)
+	})
+
+	AfterEach(func() {
+		os.Chmod(filepath.Join(dir, "secret.txt"), 0644)
+		os.RemoveAll(dir)
+	})
+
+	write := func(name string, mode os.FileMode) string {
+		p := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(p, []byte(name), 0644)).To(Succeed())
+		Expect(os.Chmod(p, mode)).To(Succeed())
+		return p
+	}
+
+	stored := func() *tagmap.TM {
+		tm, err := tagmap.NewJSONFileStore(filepath.Join(dir, ".ftag")).Load()
+		Expect(err).ToNot(HaveOccurred())
+		return tm
+	}
+
+	Describe("fileKey", func() {
+
+		DescribeTable("should key files relative to the tag map directory",
+			func(file, expected string) {
+				Expect(ftag.fileKey(filepath.Join(dir, filepath.FromSlash(file)))).To(Equal(expected))
+			},
+			Entry("at the top", "foo", "foo"),
+			Entry("in a subdirectory", "sub/foo", "sub/foo"),
+			Entry("that only start with dots", "..foo", "..foo"),
+			Entry("via a parent reference", "sub/../foo", "foo"),
+		)
+
+		DescribeTable("should refuse files outside the tag map directory",
+			func(file string) {
+				p := filepath.Join(dir, filepath.FromSlash(file))
+				_, err := ftag.fileKey(p)
+				Expect(err).To(MatchError(p + " is outside the tag map directory " + dir))
+			},
+			Entry("in the parent", ".."),
+			Entry("beside it", "../foo"),
+			Entry("in a sibling directory", "../other/foo"),
+		)
+
+		It("should not add a file outside the tag map directory", func() {
+			p := filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"-outside")
+			Expect(ioutil.WriteFile(p, nil, 0644)).To(Succeed())
+			defer os.Remove(p)
+
+			Expect(ftag.Update("add", func() error {
+				return ftag.Add(p, "t1")
+			})).ToNot(Succeed())
+			Expect(stored().FileToTag).To(BeEmpty())
+		})
+
+	})
+
+	Describe("Add", func() {
+
+		It("should store tags, attributes and a fingerprint", func() {
+			p := write("ok.txt", 0644)
+			Expect(ftag.Update("add", func() error {
+				return ftag.Add(p, "t1", "k=v")
+			})).To(Succeed())
+
+			tm := stored()
+			Expect(tm.FileToTag["ok.txt"]).To(Equal([]string{"t1"}))
+			Expect(tm.FileToAttr["ok.txt"]).To(Equal(tagmap.Attrs{"k": "v"}))
+			_, ok := tm.Fingerprint("ok.txt")
+			Expect(ok).To(BeTrue())
+		})
+
+		It("should change nothing when the file can't be fingerprinted", func() {
+			if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+				Skip("file permissions are not enforced")
+			}
+			ok := write("ok.txt", 0644)
+			secret := write("secret.txt", 0)
+
+			// As updateEach does, keep the changes to the files that succeed.
+			Expect(ftag.Update("add", func() error {
+				Expect(ftag.Add(ok, "t1")).To(Succeed())
+				Expect(ftag.Add(secret, "t1", "k=v")).ToNot(Succeed())
+				return nil
+			})).To(Succeed())
+
+			tm := stored()
+			Expect(tm.FileToTag).To(HaveKey("ok.txt"))
+			Expect(tm.FileToTag).ToNot(HaveKey("secret.txt"))
+			Expect(tm.FileToAttr).ToNot(HaveKey("secret.txt"))
+		})
+
+	})
+
+})
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"github.com/troykinsella/ftag/tagmap"
+	"strings"
+)
+
+var errNoHistory = errors.New("the tag map has no history")
+
+// Undo reverts the last n recorded operations, most recent first.
+func (ft *FTag) Undo(n int) ([]tagmap.Operation, error) {
+	if ft.history == nil {
+		return nil, errNoHistory
+	}
+
+	var ops []tagmap.Operation
+	err := ft.history.WithLock(func() error {
+		err := ft.tagMapStore.Update(func(tx tagmap.Tx) error {
+			var err error
+			ops, err = ft.history.Undo(tx, n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return ft.history.SetUndone(ops, true)
+	})
+	return ops, err
+}
+
+// Redo reapplies the last n undone operations.
+func (ft *FTag) Redo(n int) ([]tagmap.Operation, error) {
+	if ft.history == nil {
+		return nil, errNoHistory
+	}
+
+	var ops []tagmap.Operation
+	err := ft.history.WithLock(func() error {
+		err := ft.tagMapStore.Update(func(tx tagmap.Tx) error {
+			var err error
+			ops, err = ft.history.Redo(tx, n)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return ft.history.SetUndone(ops, false)
+	})
+	return ops, err
+}
+
+// History lists the recorded operations, oldest first, optionally only
+// those that changed one of files. File names in the result are display
+// paths.
+func (ft *FTag) History(files []string) ([]tagmap.Operation, error) {
+	if ft.history == nil {
+		return nil, errNoHistory
+	}
+
+	keys, err := ft.fileKeys(files)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, key := range keys {
+		wanted[key] = true
+	}
+
+	var all []tagmap.Operation
+	err = ft.tagMapStore.View(func(tx tagmap.Tx) error {
+		var err error
+		all, err = ft.history.Load()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []tagmap.Operation{}
+	for _, op := range all {
+		changes := []tagmap.FileChange{}
+		for _, c := range op.Changes {
+			if len(wanted) == 0 || wanted[c.File] {
+				c.File = ft.displayPath(c.File)
+				changes = append(changes, c)
+			}
+		}
+		if len(changes) > 0 {
+			op.Changes = changes
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+// describeChange summarizes a file change as added (+) and removed (-)
+// tags and attributes.
+func describeChange(c tagmap.FileChange) string {
+	before, after := &tagmap.FileState{}, &tagmap.FileState{}
+	if c.Before != nil {
+		before = c.Before
+	}
+	if c.After != nil {
+		after = c.After
+	}
+
+	parts := []string{}
+	diff := func(prefix string, from, to []string) {
+		has := make(map[string]bool)
+		for _, s := range to {
+			has[s] = true
+		}
+		for _, s := range from {
+			if !has[s] {
+				parts = append(parts, prefix+s)
+			}
+		}
+	}
+	diff("-", before.Tags, after.Tags)
+	diff("+", after.Tags, before.Tags)
+	diff("-", before.Attrs.Strings(), after.Attrs.Strings())
+	diff("+", after.Attrs.Strings(), before.Attrs.Strings())
+
+	if len(parts) == 0 {
+		return "fingerprint updated"
+	}
+	return strings.Join(parts, " ")
+}
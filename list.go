@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// ListByFile lists the tags of each of files, or of every file in the tag
+// map, sorted, with key=value attributes following the tags when attrs is
+// set.
+func (ft *FTag) ListByFile(files []string, attrs bool) ([]fileRecord, error) {
+	keys, err := ft.fileKeys(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		keys, err = ft.tx.Files()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	records := make([]fileRecord, len(keys))
+	for i, key := range keys {
+		tags, err := ft.tx.TagsFor(key)
+		if err != nil {
+			return nil, err
+		}
+		if attrs {
+			a, err := ft.tx.Attrs(key)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, a.Strings()...)
+		}
+		records[i] = fileRecord{File: ft.displayPath(key), Tags: tags}
+	}
+	return records, nil
+}
+
+// listByFile writes the tags of each file as "file: tag, tag", as a table,
+// or as records in another output format.
+func listByFile(c *cli.Context, ftag *FTag, files []string, format string) error {
+	records, err := ftag.ListByFile(files, c.Bool(optAttrsLong))
+	if err != nil {
+		return err
+	}
+
+	if format == outputText {
+		if c.Bool(optTableLong) {
+			return printFileTable(records)
+		}
+		for _, r := range records {
+			fmt.Printf("%s: %s\n", r.File, strings.Join(r.Tags, ", "))
+		}
+		return nil
+	}
+
+	rs := make([]record, len(records))
+	for i, r := range records {
+		rs[i] = r
+	}
+	return writeRecords(os.Stdout, format, fileColumns, rs)
+}
+
+func printFileTable(records []fileRecord) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tTAGS")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\n", r.File, strings.Join(r.Tags, ", "))
+	}
+	return w.Flush()
+}
+
+// listUntagged writes the files under dirs, or the current directory,
+// that have no tags.
+func listUntagged(c *cli.Context, ftag *FTag, dirs []string, format string) error {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	own, err := getTagMapFiles(c)
+	if err != nil {
+		return err
+	}
+
+	files, err := ftag.Untagged(dirs, own)
+	if err != nil {
+		return err
+	}
+
+	records := make([]record, len(files))
+	for i, f := range files {
+		records[i] = fileRecord{File: f, Tags: []string{}}
+	}
+	return writeRecords(os.Stdout, format, fileColumns, records)
+}
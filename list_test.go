@@ -0,0 +1,98 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("list", func() {
+
+	var cwd string
+	var dir string
+	var ftag *FTag
+
+	BeforeEach(func() {
+		var err error
+		cwd, err = os.Getwd()
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = filepath.EvalSymlinks(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Chdir(dir)).To(Succeed())
+
+		for _, name := range []string{"a", "b", "sub/c", ".ftagignore"} {
+			Expect(os.MkdirAll(filepath.Dir(name), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(name, nil, 0644)).To(Succeed())
+		}
+
+		ftag = New(tagmap.NewJSONFileStore(filepath.Join(dir, ".ftag")), dir)
+		Expect(ftag.Update("add", func() error {
+			Expect(ftag.Add("a", "t2", "t1", "k=v")).To(Succeed())
+			return ftag.Add("sub/c", "t1")
+		})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	})
+
+	Describe("ListByFile", func() {
+
+		It("should list the tags of every file", func() {
+			Expect(ftag.View(func() error {
+				records, err := ftag.ListByFile(nil, false)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(records).To(Equal([]fileRecord{
+					{File: "a", Tags: []string{"t1", "t2"}},
+					{File: filepath.FromSlash("sub/c"), Tags: []string{"t1"}},
+				}))
+				return nil
+			})).To(Succeed())
+		})
+
+		It("should list the tags and attributes of the given files", func() {
+			Expect(ftag.View(func() error {
+				records, err := ftag.ListByFile([]string{"a", "b"}, true)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(records).To(Equal([]fileRecord{
+					{File: "a", Tags: []string{"t1", "t2", "k=v"}},
+					{File: "b", Tags: []string{}},
+				}))
+				return nil
+			})).To(Succeed())
+		})
+
+	})
+
+	Describe("Untagged", func() {
+
+		It("should list the files with no tags, other than the tag map's own", func() {
+			Expect(ftag.View(func() error {
+				files, err := ftag.Untagged([]string{"."}, &tagMapFiles{dir: dir, mapName: ".ftag"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(files).To(ConsistOf(".ftagignore", "b"))
+				return nil
+			})).To(Succeed())
+		})
+
+	})
+
+	DescribeTable("should reject conflicting flags",
+		func(expected string, args ...string) {
+			Expect(runCommand(commandList, append([]string{"list"}, args...)...)).To(MatchError(expected))
+		},
+		Entry("--tree with --long", "--tree lists tags only", "--tree", "-l"),
+		Entry("--tree with --table", "--tree lists tags only", "--tree", "--table"),
+		Entry("--tree with --untagged", "--tree lists tags only", "--tree", "--untagged"),
+		Entry("--untagged with --long", "--untagged lists files without tags", "--untagged", "--long"),
+		Entry("--untagged with --table", "--untagged lists files without tags", "--untagged", "--table"),
+	)
+
+})
@@ -3,10 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/troykinsella/ftag/query"
 	"github.com/troykinsella/ftag/tagmap"
 	"github.com/urfave/cli"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -15,18 +19,72 @@ const (
 	optTagMap     = "m"
 	optTagMapLong = "tag-map"
 
-	defaultTagMap = ".ftag"
+	envTagMap = "FTAG_MAP"
+
+	optStoreLong = "store"
+
+	optOutputLong = "output"
+
+	optFromLong = "from"
+	optToLong   = "to"
+	optDestLong = "dest"
+
+	optLockTimeoutLong = "lock-timeout"
+
+	optBackupsLong = "backups"
+
+	optCompactAfterLong = "compact-after"
+
+	optHistoryLong = "history"
+
+	optCheckLong = "check"
+
+	optTree     = "t"
+	optTreeLong = "tree"
+
+	optAttrs     = "a"
+	optAttrsLong = "attrs"
+
+	optLong         = "l"
+	optLongLong     = "long"
+	optTableLong    = "table"
+	optUntaggedLong = "untagged"
+
+	optFixLong = "fix"
+
+	optDryRun     = "n"
+	optDryRunLong = "dry-run"
+
+	optRecursive     = "r"
+	optRecursiveLong = "recursive"
+
+	optTag     = "t"
+	optTagLong = "tag"
+
+	optGlobLong = "glob"
+
+	optIntoLong  = "into"
+	optMergeLong = "merge"
+
+	optTopLong = "top"
+
+	optRegexLong = "regex"
+
+	optUnderLong = "under"
+	optPathLong  = "path"
+	optNewerLong = "newer"
+	optSizeLong  = "size"
+	optTypeLong  = "type"
+
+	optStdinLong = "stdin"
+	optNull      = "0"
+	optNullLong  = "null"
 )
 
 var (
 	AppVersion = "0.0.0-dev.0"
 )
 
-func getTagMapPath(c *cli.Context) (string, error) {
-	p := c.GlobalString(optTagMap)
-	return resolvePath(p)
-}
-
 func resolvePath(p string) (string, error) {
 	if !filepath.IsAbs(p) {
 		cwd, err := os.Getwd()
@@ -39,23 +97,70 @@ func resolvePath(p string) (string, error) {
 	return p, nil
 }
 
-func createFTag(c *cli.Context) (*FTag, error) {
+// reportSkipped tells of a file that a walk of a directory tree couldn't
+// read, and went on without.
+func reportSkipped(err error) {
+	fmt.Fprintf(os.Stderr, "skipping: %s\n", err)
+}
 
-	tagMapPath, err := getTagMapPath(c)
+func newFTag(c *cli.Context) (*FTag, error) {
+	kind := c.GlobalString(optStoreLong)
+	tagMapStore, root, err := openTagMapStore(c, kind)
 	if err != nil {
 		return nil, err
 	}
 
-	tagMapStore := tagmap.NewJSONFileStore(tagMapPath)
+	ftag := New(tagMapStore, root)
+	ftag.onSkip = reportSkipped
+
+	if limit := c.GlobalInt(optHistoryLong); limit > 0 {
+		p, err := getHistoryPath(c, kind, root)
+		if err != nil {
+			return nil, err
+		}
+		ftag.history = tagmap.NewHistory(p)
+		ftag.history.Limit = limit
+		ftag.history.LockTimeout = c.GlobalDuration(optLockTimeoutLong)
+	}
+
+	return ftag, nil
+}
+
+func viewFTag(c *cli.Context, fn func(ftag *FTag) error) error {
+	ftag, err := newFTag(c)
+	if err != nil {
+		return err
+	}
 
-	ftag := New(tagMapStore)
+	return ftag.View(func() error {
+		return fn(ftag)
+	})
+}
 
-	err = ftag.LoadTagMap()
+func updateFTag(c *cli.Context, fn func(ftag *FTag) error) error {
+	ftag, err := newFTag(c)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return ftag, nil
+	command := strings.TrimSpace(AppName + " " + c.Command.FullName() + " " + strings.Join(c.Args(), " "))
+	return ftag.Update(command, func() error {
+		return fn(ftag)
+	})
+}
+
+// getCountArg returns the optional count argument of undo and redo.
+func getCountArg(c *cli.Context) (int, error) {
+	arg := c.Args().First()
+	if arg == "" {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count: %s", arg)
+	}
+	return n, nil
 }
 
 func getFileArg(c *cli.Context) (string, error) {
@@ -78,41 +183,94 @@ func getTagArgs(c *cli.Context) ([]string, error) {
 
 func commandAdd(c *cli.Context) error {
 
-	f, err := getFileArg(c)
+	files, tags, err := getFilesAndTags(c, c.StringSlice(optTagLong))
 	if err != nil {
 		return err
 	}
 
-	tags, err := getTagArgs(c)
+	own, err := getTagMapFiles(c)
 	if err != nil {
 		return err
 	}
 
-	ftag, err := createFTag(c)
+	files, err = expandPaths(files, c.Bool(optRecursiveLong), c.String(optGlobLong), own)
 	if err != nil {
 		return err
 	}
 
-	err = ftag.Add(f, tags...)
-	if err != nil {
-		return err
+	return updateEach(c, files, nil, func(ftag *FTag, f string) error {
+		return ftag.Add(f, tags...)
+	})
+}
+
+// getFilesAndTags splits the arguments of add and remove. With tags given
+// by flag or files read from --stdin, the arguments are all files or all
+// tags respectively; otherwise the first argument is the file and the rest
+// are tags.
+func getFilesAndTags(c *cli.Context, tags []string) ([]string, []string, error) {
+	files := c.Args()
+	switch {
+	case len(tags) > 0:
+		if len(files) == 0 && !c.Bool(optStdinLong) {
+			cli.ShowSubcommandHelp(c)
+			return nil, nil, errors.New("must supply a file argument")
+		}
+
+	case c.Bool(optStdinLong):
+		tags, files = files, nil
+		if len(tags) == 0 {
+			cli.ShowSubcommandHelp(c)
+			return nil, nil, errors.New("must supply a tag")
+		}
+
+	default:
+		f, err := getFileArg(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		tags, err = getTagArgs(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = []string{f}
 	}
 
-	err = ftag.StoreTagMap()
+	files, _, err := getFileList(c, files)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-
-	return nil
+	return files, tags, nil
 }
 
 func commandCheck(c *cli.Context) error {
-	ftag, err := createFTag(c)
-	if err != nil {
-		return err
+	var errs []error
+
+	if c.Bool(optFixLong) {
+		err := updateFTag(c, func(ftag *FTag) error {
+			fixed, err := ftag.Fix()
+			if err != nil {
+				return err
+			}
+			for _, f := range fixed {
+				fmt.Println("fixed: " + f.Error())
+			}
+			errs, err = ftag.Check()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		err := viewFTag(c, func(ftag *FTag) error {
+			var err error
+			errs, err = ftag.Check()
+			return err
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	errs := ftag.Check()
 	if len(errs) > 0 {
 		return cli.NewMultiError(errs...)
 	}
@@ -121,51 +279,378 @@ func commandCheck(c *cli.Context) error {
 }
 
 func commandClear(c *cli.Context) error {
-	ftag, err := createFTag(c)
+	files, _, err := getFileList(c, c.Args())
+	if err != nil {
+		return err
+	}
+
+	return updateFTag(c, func(ftag *FTag) error {
+		return ftag.Clear(files...)
+	})
+}
+
+func commandCompact(c *cli.Context) error {
+	tagMapStore, _, err := openTagMapStore(c, c.GlobalString(optStoreLong))
 	if err != nil {
 		return err
 	}
 
-	ftag.Clear(c.Args()...)
+	journalStore, ok := tagMapStore.(*tagmap.JournalStore)
+	if !ok {
+		return fmt.Errorf("only the %s store can be compacted", storeJournal)
+	}
+
+	return journalStore.Compact()
+}
+
+func commandConvert(c *cli.Context) error {
+	from := c.String(optFromLong)
+	to := c.String(optToLong)
+	if err := checkStoreKind(to); err != nil {
+		return err
+	}
+	if from == to {
+		return errors.New("the source and destination stores must differ")
+	}
 
-	err = ftag.StoreTagMap()
+	src, root, err := openTagMapStore(c, from)
 	if err != nil {
 		return err
 	}
 
+	p := c.String(optDestLong)
+	if p == "" && to != storeXattr {
+		p = filepath.Join(root, defaultTagMapName(to))
+	}
+	if p == "" {
+		p = root
+	}
+	p, err = resolvePath(p)
+	if err != nil {
+		return err
+	}
+
+	dst, err := createTagMapStore(c, to, p)
+	if err != nil {
+		return err
+	}
+
+	tm, err := src.Load()
+	if err != nil {
+		return err
+	}
+
+	err = dst.Put(tm)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %d files from %s to %s in %s\n", len(tm.ListFiles()), from, to, p)
 	return nil
 }
 
 func commandFind(c *cli.Context) error {
-	tags := c.Args()
-	if len(tags) == 0 {
+	if len(c.Args()) == 0 {
 		cli.ShowSubcommandHelp(c)
 		return errors.New("must supply a tag expression")
 	}
+	expr := query.JoinArgs(c.Args(), c.Bool(optRegexLong))
 
-	ftag, err := createFTag(c)
+	format, err := getOutputFormat(c)
 	if err != nil {
 		return err
 	}
 
-	files := ftag.Find(tags...)
-	for _, f := range files {
-		fmt.Println(f)
+	filter, err := getFileFilter(c)
+	if err != nil {
+		return err
+	}
+
+	return viewFTag(c, func(ftag *FTag) error {
+		files, err := ftag.Find(expr, c.Bool(optRegexLong))
+		if err != nil {
+			return err
+		}
+		files, err = filter.apply(files)
+		if err != nil {
+			return err
+		}
+
+		records := make([]record, len(files))
+		for i, f := range files {
+			r := fileRecord{File: f}
+			if format != outputText && format != outputNull {
+				r.Tags, err = ftag.TagsFor(f)
+				if err != nil {
+					return err
+				}
+			}
+			records[i] = r
+		}
+		return writeRecords(os.Stdout, format, fileColumns, records)
+	})
+}
+
+func commandFmt(c *cli.Context) error {
+	tagMapStore, _, err := openTagMapStore(c, c.GlobalString(optStoreLong))
+	if err != nil {
+		return err
+	}
+
+	formatter, ok := tagMapStore.(tagmap.Formatter)
+	if !ok {
+		return fmt.Errorf("only the %s store can be formatted", storeJSON)
+	}
+
+	check := c.Bool(optCheckLong)
+	changed, err := formatter.Format(check)
+	if err != nil {
+		return err
+	}
+	if changed && check {
+		return errors.New("the tag map is not formatted; run '" + AppName + " fmt'")
 	}
 
 	return nil
 }
 
+func commandHistory(c *cli.Context) error {
+	ftag, err := newFTag(c)
+	if err != nil {
+		return err
+	}
+
+	ops, err := ftag.History(c.Args())
+	if err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		undone := ""
+		if op.Undone {
+			undone = " (undone)"
+		}
+		fmt.Printf("%d  %s  %s  %s%s\n", op.ID, op.Time.Local().Format("2006-01-02 15:04:05"), op.User, op.Command, undone)
+		for _, change := range op.Changes {
+			fmt.Printf("    %s: %s\n", change.File, describeChange(change))
+		}
+	}
+
+	return nil
+}
+
+func commandInit(c *cli.Context) error {
+	kind := c.GlobalString(optStoreLong)
+	if err := checkStoreKind(kind); err != nil {
+		return err
+	}
+	if kind == storeXattr {
+		return fmt.Errorf("init is not needed for the %s store", storeXattr)
+	}
+
+	p := c.GlobalString(optTagMap)
+	if p == "" {
+		p = defaultTagMapName(kind)
+	}
+
+	tagMapPath, err := resolvePath(p)
+	if err != nil {
+		return err
+	}
+
+	tagMapStore, err := createTagMapStore(c, kind, tagMapPath)
+	if err != nil {
+		return err
+	}
+
+	err = tagMapStore.Put(tagmap.New())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Initialized empty tag map in " + tagMapPath)
+	return nil
+}
+
 func commandList(c *cli.Context) error {
+	files, fromStdin, err := getFileList(c, c.Args())
+	if err != nil {
+		return err
+	}
+	if fromStdin && len(files) == 0 {
+		return nil
+	}
+
+	format, err := getOutputFormat(c)
+	if err != nil {
+		return err
+	}
+
+	byFile := c.Bool(optLongLong) || c.Bool(optTableLong)
+	if c.Bool(optTreeLong) && (byFile || c.Bool(optUntaggedLong)) {
+		return fmt.Errorf("--%s lists tags only", optTreeLong)
+	}
+	if c.Bool(optUntaggedLong) && byFile {
+		return fmt.Errorf("--%s lists files without tags", optUntaggedLong)
+	}
+	if c.Bool(optUntaggedLong) {
+		return viewFTag(c, func(ftag *FTag) error {
+			return listUntagged(c, ftag, files, format)
+		})
+	}
+	if byFile {
+		return viewFTag(c, func(ftag *FTag) error {
+			return listByFile(c, ftag, files, format)
+		})
+	}
+
+	if format != outputText {
+		if c.Bool(optTreeLong) {
+			return fmt.Errorf("--%s is not supported with %s output", optTreeLong, format)
+		}
+		return viewFTag(c, func(ftag *FTag) error {
+			return listCounts(ftag, files, c.Bool(optAttrsLong), format)
+		})
+	}
+
+	return viewFTag(c, func(ftag *FTag) error {
+		tags, err := ftag.List(files)
+		if err != nil {
+			return err
+		}
+
+		if c.Bool(optTreeLong) {
+			printTagTree(tagmap.NewTagTree(tags), "")
+		} else {
+			for _, t := range tags {
+				fmt.Println(t)
+			}
+		}
+
+		if c.Bool(optAttrsLong) {
+			attrs, err := ftag.ListAttrs(files)
+			if err != nil {
+				return err
+			}
+			for _, a := range attrs {
+				fmt.Println(a)
+			}
+		}
+
+		return nil
+	})
+}
+
+// listCounts writes the tags of files, or of all files, with the number
+// of those files having each.
+func listCounts(ftag *FTag, files []string, attrs bool, format string) error {
+	counts, err := ftag.CountTags(files, attrs)
+	if err != nil {
+		return err
+	}
+
+	tags := make([]string, 0, len(counts))
+	for tag := range counts {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	records := make([]record, len(tags))
+	for i, tag := range tags {
+		records[i] = tagRecord{Tag: tag, Files: counts[tag]}
+	}
+	return writeRecords(os.Stdout, format, tagColumns, records)
+}
+
+func printTagTree(node *tagmap.TagNode, indent string) {
+	for i, child := range node.Children {
+		branch, next := "├── ", "│   "
+		if i == len(node.Children)-1 {
+			branch, next = "└── ", "    "
+		}
+		if node.Tag == "" {
+			branch, next = "", ""
+		}
+
+		fmt.Println(indent + branch + child.Label)
+		printTagTree(child, indent+next)
+	}
+}
+
+func commandMergeDriver(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 3 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("must supply the base, our and their versions of the tag map")
+	}
+	basePath, oursPath, theirsPath := args[0], args[1], args[2]
+
+	// Git runs merge drivers from the top of the work tree, and %P names
+	// the tag map relative to it.
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if p := args.Get(3); p != "" {
+		root = filepath.Join(root, filepath.Dir(p))
+	}
+
+	var versions []*tagmap.TM
+	for _, p := range []string{basePath, oursPath, theirsPath} {
+		tm, err := tagmap.ReadJSONFile(p, root)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, tm)
+	}
 
-	ftag, err := createFTag(c)
+	merged, conflicts := tagmap.Merge(versions[0], versions[1], versions[2])
+	err = tagmap.WriteJSONFile(oursPath, merged)
 	if err != nil {
 		return err
 	}
 
-	tags := ftag.List(c.Args())
-	for _, t := range tags {
-		fmt.Println(t)
+	if len(conflicts) > 0 {
+		errs := make([]error, len(conflicts))
+		for i, conflict := range conflicts {
+			errs[i] = conflict
+		}
+		return cli.NewMultiError(errs...)
+	}
+	return nil
+}
+
+func commandMigrate(c *cli.Context) error {
+	tagMapStore, _, err := openTagMapStore(c, c.GlobalString(optStoreLong))
+	if err != nil {
+		return err
+	}
+
+	migrator, ok := tagMapStore.(tagmap.Migrator)
+	if !ok {
+		return errors.New("the tag map store does not support migration")
+	}
+
+	dryRun := c.Bool(optDryRunLong)
+	steps, err := migrator.Migrate(dryRun)
+	if err != nil {
+		return err
+	}
+
+	if len(steps) == 0 {
+		fmt.Println("Tag map is up to date (version " + tagmap.TM_VERSION + ")")
+		return nil
+	}
+
+	for _, step := range steps {
+		fmt.Printf("%s -> %s: %s\n", step.From, step.To, step.Description)
+		for _, change := range step.Changes {
+			fmt.Println("  " + change)
+		}
+	}
+
+	if dryRun {
+		fmt.Println("Dry run; the tag map was not changed")
 	}
 
 	return nil
@@ -184,48 +669,159 @@ func commandMove(c *cli.Context) error {
 		return errors.New("must supply a 'to' file argument")
 	}
 
-	ftag, err := createFTag(c)
+	return updateFTag(c, func(ftag *FTag) error {
+		return ftag.Move(from, to)
+	})
+}
+
+func commandTagRename(c *cli.Context) error {
+	if c.NArg() != 2 {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("must supply an old and a new tag")
+	}
+	from, to := c.Args().Get(0), c.Args().Get(1)
+
+	return updateFTag(c, func(ftag *FTag) error {
+		files, err := ftag.RenameTag(from, to, c.Bool(optMergeLong))
+		if err != nil {
+			return err
+		}
+		printTagged(files, fmt.Sprintf("Renamed tag %q to %q", from, to))
+		return nil
+	})
+}
+
+func commandTagMerge(c *cli.Context) error {
+	into := c.String(optIntoLong)
+	if c.NArg() == 0 || into == "" {
+		cli.ShowSubcommandHelp(c)
+		return errors.New("must supply tags to merge and --" + optIntoLong)
+	}
+	tags := c.Args()
+
+	return updateFTag(c, func(ftag *FTag) error {
+		files, err := ftag.MergeTags(tags, into)
+		if err != nil {
+			return err
+		}
+		printTagged(files, fmt.Sprintf("Merged %s into %q", strings.Join(tags, ", "), into))
+		return nil
+	})
+}
+
+func printTagged(files []string, summary string) {
+	fmt.Printf("%s on %d files\n", summary, len(files))
+	for _, f := range files {
+		fmt.Println("  " + f)
+	}
+}
+
+func commandRedo(c *cli.Context) error {
+	n, err := getCountArg(c)
 	if err != nil {
 		return err
 	}
 
-	err = ftag.Move(from, to)
+	ftag, err := newFTag(c)
 	if err != nil {
 		return err
 	}
 
-	err = ftag.StoreTagMap()
+	ops, err := ftag.Redo(n)
 	if err != nil {
 		return err
 	}
+	if len(ops) == 0 {
+		return errors.New("nothing to redo")
+	}
 
+	for _, op := range ops {
+		fmt.Println("redid: " + op.Command)
+	}
 	return nil
 }
 
 func commandRemove(c *cli.Context) error {
 
-	f, err := getFileArg(c)
+	files, tags, err := getFilesAndTags(c, nil)
 	if err != nil {
 		return err
 	}
 
-	tags, err := getTagArgs(c)
+	expand := func(ftag *FTag) error {
+		tags, err = ftag.ExpandTags(tags, c.Bool(optRegexLong))
+		return err
+	}
+
+	return updateEach(c, files, expand, func(ftag *FTag, f string) error {
+		if c.Bool(optRecursiveLong) {
+			return ftag.RemoveRecursive(f, tags...)
+		}
+		return ftag.Remove(f, tags...)
+	})
+}
+
+func commandRepair(c *cli.Context) error {
+	dryRun := c.Bool(optDryRunLong)
+
+	var results []RepairResult
+	repair := func(ftag *FTag) error {
+		var err error
+		results, err = ftag.Repair(dryRun)
+		return err
+	}
+
+	update := updateFTag
+	if dryRun {
+		update = viewFTag
+	}
+	err := update(c, repair)
 	if err != nil {
 		return err
 	}
 
-	ftag, err := createFTag(c)
+	for _, r := range results {
+		switch r.Status {
+		case RepairMoved:
+			if dryRun {
+				fmt.Printf("would move: %s -> %s\n", r.From, r.To)
+			} else {
+				fmt.Printf("moved: %s -> %s\n", r.From, r.To)
+			}
+		case RepairAmbiguous:
+			fmt.Printf("ambiguous: %s matches %s\n", r.From, strings.Join(r.Candidates, ", "))
+		case RepairUnmatched:
+			fmt.Printf("not found: %s\n", r.From)
+		case RepairNoFingerprint:
+			fmt.Printf("no fingerprint: %s\n", r.From)
+		}
+	}
+
+	return nil
+}
+
+func commandUndo(c *cli.Context) error {
+	n, err := getCountArg(c)
 	if err != nil {
 		return err
 	}
 
-	ftag.Remove(f, tags...)
+	ftag, err := newFTag(c)
+	if err != nil {
+		return err
+	}
 
-	err = ftag.StoreTagMap()
+	ops, err := ftag.Undo(n)
 	if err != nil {
 		return err
 	}
+	if len(ops) == 0 {
+		return errors.New("nothing to undo")
+	}
 
+	for _, op := range ops {
+		fmt.Println("undid: " + op.Command)
+	}
 	return nil
 }
 
@@ -240,37 +836,182 @@ func newCliApp() *cli.App {
 
 	app.Commands = []cli.Command{
 		{
-			Name:      "add",
-			Aliases:   []string{"a"},
-			Usage:     "Add one ore more tags to a file",
-			UsageText: AppName + " add <file> <tag> [tag...]",
-			Action:    commandAdd,
+			Name:    "add",
+			Aliases: []string{"a"},
+			Usage:   "Add one ore more tags or key=value attributes to files",
+			UsageText: AppName + " add [--recursive] [--glob <pattern>] <file|-> <tag|key=value> [tag|key=value...]\n   " +
+				AppName + " add [--recursive] [--glob <pattern>] --tag <tag|key=value> [--tag <tag|key=value>...] <file|-> [file...]\n   " +
+				AppName + " add [--recursive] [--glob <pattern>] --stdin [-0] <tag|key=value> [tag|key=value...]",
+			Action: commandAdd,
+			Flags: append(stdinFlags(),
+				cli.StringSliceFlag{
+					Name:  optTag + ", " + optTagLong,
+					Usage: "A tag or key=value attribute to add; when given, all arguments are files",
+				},
+				cli.BoolFlag{
+					Name:  optRecursive + ", " + optRecursiveLong,
+					Usage: "Tag the files in directories and their subdirectories instead of the directories",
+				},
+				cli.StringFlag{
+					Name:  optGlobLong,
+					Usage: "Only tag files found in directories whose name matches the pattern, e.g. '*.md'",
+				},
+			),
 		},
 		{
-			Name:   "check",
-			Usage:  "Verify that files referenced in the tag mapping exist",
-			Action: commandCheck,
+			Name:      "check",
+			Usage:     "Verify the consistency of the tag mapping and that the files it references exist",
+			UsageText: AppName + " check [--fix]",
+			Action:    commandCheck,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  optFixLong,
+					Usage: "Rebuild the tag-to-file index from the file-to-tag mapping",
+				},
+			},
 		},
 		{
 			Name:      "clear",
 			Aliases:   []string{"clr"},
 			Usage:     "Clear all tags associated with the given files",
-			UsageText: AppName + " clear <file> [file...]",
+			UsageText: AppName + " clear [--stdin [-0]] <file|-> [file...]",
 			Action:    commandClear,
+			Flags:     stdinFlags(),
+		},
+		{
+			Name:      "compact",
+			Usage:     "Fold the journal of the " + storeJournal + " store into the tag map",
+			UsageText: AppName + " --store " + storeJournal + " compact",
+			Action:    commandCompact,
+		},
+		{
+			Name:      "convert",
+			Usage:     "Copy the tag map into a different kind of store",
+			UsageText: AppName + " convert --from <store> --to <store> [--dest <path>]",
+			Action:    commandConvert,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  optFromLong,
+					Value: storeJSON,
+					Usage: "The store to read: " + strings.Join(storeKinds, ", "),
+				},
+				cli.StringFlag{
+					Name:  optToLong,
+					Value: storeSQLite,
+					Usage: "The store to write: " + strings.Join(storeKinds, ", "),
+				},
+				cli.StringFlag{
+					Name:  optDestLong,
+					Usage: "Where to write the new tag map (default: next to the source, e.g. " + defaultSQLiteTagMap + ")",
+				},
+			},
 		},
 		{
 			Name:      "find",
 			Aliases:   []string{"f"},
-			Usage:     "Lookup files associated with the given tags",
-			UsageText: AppName + " find <tag> [tag...]",
+			Usage:     "Lookup files matching the given tag expression",
+			UsageText: AppName + " find [--regex] [--under <dir>] [--path <pattern>] [--newer <time>] [--size [+|-]<size>] [--type f|d] [-0] <expression>\n\n   Tags may be combined with 'and', 'or', 'not' and parentheses,\n   e.g. '(draft or review) and not archived'. Adjacent tags are\n   implicitly joined with 'and'. A tag containing '*', '?' or '['\n   matches tags by pattern, e.g. 'proj-*', unless it is quoted.",
 			Action:    commandFind,
+			Flags: []cli.Flag{
+				nullFlag,
+				cli.StringSliceFlag{
+					Name:  optUnderLong,
+					Usage: "Only find files in the directory or its subdirectories",
+				},
+				cli.StringFlag{
+					Name:  optPathLong,
+					Usage: "Only find files whose path matches the pattern, or whose name does for a pattern without '/', e.g. '*.md'",
+				},
+				cli.StringFlag{
+					Name:  optNewerLong,
+					Usage: "Only find files modified after a date or time, e.g. 2026-01-01 or 2026-01-01T09:00:00, or within a duration, e.g. 36h or 7d",
+				},
+				cli.StringFlag{
+					Name:  optSizeLong,
+					Usage: "Only find files of a size in bytes or with a k, M, G or T suffix; +10M for larger, -10M for smaller",
+				},
+				cli.StringFlag{
+					Name:  optTypeLong,
+					Usage: "Only find regular files (" + typeFile + ") or directories (" + typeDir + ")",
+				},
+				cli.BoolFlag{
+					Name:  optRegexLong,
+					Usage: "Match tags by regular expression, quoting those containing special characters, e.g. '\"^(v1|v2)$\"'",
+				},
+			},
+		},
+		{
+			Name:      "fmt",
+			Usage:     "Rewrite the tag map in canonical form",
+			UsageText: AppName + " fmt [--check]",
+			Action:    commandFmt,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  optCheckLong,
+					Usage: "Fail if the tag map is not in canonical form, without changing it",
+				},
+			},
+		},
+		{
+			Name:      "history",
+			Usage:     "Show who changed which tags when, optionally only for the given files",
+			UsageText: AppName + " history [file...]",
+			Action:    commandHistory,
+		},
+		{
+			Name:      "init",
+			Usage:     "Create an empty tag map in the current directory, or at the --" + optTagMapLong + " path",
+			UsageText: AppName + " init",
+			Action:    commandInit,
 		},
 		{
-			Name:      "list",
-			Aliases:   []string{"ls"},
-			Usage:     "List tags associated with the given files",
-			UsageText: AppName + " list [file...]",
-			Action:    commandList,
+			Name:    "list",
+			Aliases: []string{"ls"},
+			Usage:   "List tags associated with the given files",
+			UsageText: AppName + " list [--tree] [--attrs] [--stdin [-0]] [file|-...]\n   " +
+				AppName + " list --long|--table [--attrs] [--stdin [-0]] [file|-...]\n   " +
+				AppName + " list --untagged [directory...]",
+			Action: commandList,
+			Flags: append(stdinFlags(),
+				cli.BoolFlag{
+					Name:  optAttrs + ", " + optAttrsLong,
+					Usage: "Also list key" + tagmap.AttrSeparator + "value attributes",
+				},
+				cli.BoolFlag{
+					Name:  optTree + ", " + optTreeLong,
+					Usage: "Render tags as a hierarchy split on '" + tagmap.TagSeparator + "'",
+				},
+				cli.BoolFlag{
+					Name:  optLong + ", " + optLongLong,
+					Usage: "List the tags of each file separately, as 'file: tag, tag'",
+				},
+				cli.BoolFlag{
+					Name:  optTableLong,
+					Usage: "List the tags of each file separately, in columns",
+				},
+				cli.BoolFlag{
+					Name:  optUntaggedLong,
+					Usage: "List the files under the given directories, or the current directory, that have no tags",
+				},
+			),
+		},
+		{
+			Name:      "merge-driver",
+			Usage:     "Merge two versions of a " + defaultTagMap + " file changed from a common base, as a git merge driver",
+			UsageText: AppName + " merge-driver <base> <ours> <theirs> [path]",
+			Action:    commandMergeDriver,
+		},
+		{
+			Name:      "migrate",
+			Usage:     "Upgrade the tag map to the current format version",
+			UsageText: AppName + " migrate [--dry-run]",
+			Action:    commandMigrate,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  optDryRun + ", " + optDryRunLong,
+					Usage: "Show the migration steps and changes without writing the tag map",
+				},
+			},
 		},
 		{
 			Name:      "move",
@@ -280,28 +1021,206 @@ func newCliApp() *cli.App {
 			Action:    commandMove,
 		},
 		{
-			Name:      "remove",
-			Aliases:   []string{"rm"},
-			Usage:     "Remove one or more tags from a file",
-			UsageText: AppName + " remove <file> <tag> [tag...]",
-			Action:    commandRemove,
+			Name:      "repair",
+			Usage:     "Find files that were moved without " + AppName + " by content and update the tag mapping",
+			UsageText: AppName + " repair [--dry-run]",
+			Action:    commandRepair,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  optDryRun + ", " + optDryRunLong,
+					Usage: "Report what would be moved without changing the tag map",
+				},
+			},
+		},
+		{
+			Name:      "redo",
+			Usage:     "Reapply the last n undone operations",
+			UsageText: AppName + " redo [n]",
+			Action:    commandRedo,
+		},
+		{
+			Name:    "remove",
+			Aliases: []string{"rm"},
+			Usage:   "Remove one or more tags from a file",
+			UsageText: AppName + " remove [--recursive] [--regex] <file|-> <tag|pattern|key=[value]> [tag|pattern|key=[value]...]\n   " +
+				AppName + " remove [--recursive] [--regex] --stdin [-0] <tag|pattern|key=[value]> [tag|pattern|key=[value]...]",
+			Action: commandRemove,
+			Flags: append(stdinFlags(),
+				cli.BoolFlag{
+					Name:  optRecursive + ", " + optRecursiveLong,
+					Usage: "Also remove tags nested under the given tags, e.g. project/alpha/design under project/alpha",
+				},
+				cli.BoolFlag{
+					Name:  optRegexLong,
+					Usage: "Remove the tags matching regular expressions instead of glob patterns like 'tmp-*'",
+				},
+			),
+		},
+		{
+			Name:      "stats",
+			Usage:     "Report how tags are used, untagged files under the tag map's directory and missing files",
+			UsageText: AppName + " stats [--top <n>]",
+			Action:    commandStats,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  optTopLong,
+					Value: 10,
+					Usage: "Number of pairs of tags most often found together to list, or 0 for all",
+				},
+			},
+		},
+		{
+			Name:  "tag",
+			Usage: "Change tags across all files",
+			Subcommands: []cli.Command{
+				{
+					Name:      "merge",
+					Usage:     "Replace several tags with one on every file",
+					UsageText: AppName + " tag merge <tag> [tag...] --into <tag>",
+					Action:    commandTagMerge,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  optIntoLong,
+							Usage: "The tag to merge into, which may already be in use",
+						},
+					},
+				},
+				{
+					Name:      "rename",
+					Usage:     "Rename a tag on every file",
+					UsageText: AppName + " tag rename [--merge] <old> <new>",
+					Action:    commandTagRename,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  optMergeLong,
+							Usage: "Combine with the new tag if it is already in use, instead of failing",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "undo",
+			Usage:     "Revert the last n operations that changed the tag map",
+			UsageText: AppName + " undo [n]",
+			Action:    commandUndo,
 		},
 	}
 
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
-			Name:  optTagMap + ", " + optTagMapLong,
-			Value: defaultTagMap,
-			Usage: "",
+			Name:   optTagMap + ", " + optTagMapLong,
+			EnvVar: envTagMap,
+			Usage:  "Path to the tag map file (default: nearest " + defaultTagMap + ", or " + defaultJournalTagMap + " for the " + storeJournal + " store and " + defaultSQLiteTagMap + " for the " + storeSQLite + " store, in the current directory or its parents)",
+		},
+		cli.StringFlag{
+			Name:  optStoreLong,
+			Value: storeJSON,
+			Usage: "Where tags are kept: '" + storeJSON + "' for a " + defaultTagMap + " file, '" + storeJournal + "' for a " + defaultJournalTagMap + " file with an append-only journal of changes, '" + storeSQLite + "' for a " + defaultSQLiteTagMap + " database, or '" + storeXattr + "' for extended attributes on each file (" + tagmap.XattrTags + "), scanned from the current directory",
+		},
+		cli.DurationFlag{
+			Name:  optLockTimeoutLong,
+			Value: tagmap.DefaultLockTimeout,
+			Usage: "How long to wait for another " + AppName + " process to release the tag map",
+		},
+		cli.IntFlag{
+			Name:  optCompactAfterLong,
+			Value: tagmap.DefaultCompactAfter,
+			Usage: "Number of journal entries after which the " + storeJournal + " store folds its journal into the tag map, or 0 to never do so automatically",
+		},
+		cli.IntFlag{
+			Name:  optHistoryLong,
+			Value: tagmap.DefaultHistoryLimit,
+			Usage: "Number of operations to keep for undo, redo and history, or 0 to keep none",
+		},
+		cli.StringFlag{
+			Name:  optOutputLong,
+			Value: outputText,
+			Usage: "Format of the results of find, list and stats: '" + strings.Join(outputFormats, "', '") + "'",
+		},
+		cli.IntFlag{
+			Name:  optBackupsLong,
+			Usage: "Number of rolling backups (" + defaultTagMap + ".bak, " + defaultTagMap + ".bak.2, ...) to keep when the tag map is written",
 		},
 	}
 
 	return app
 }
 
+// flagNames lists the names a flag can be given as on the command line.
+func flagNames(f cli.Flag) []string {
+	names := []string{}
+	for _, name := range strings.Split(f.GetName(), ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 1 {
+			names = append(names, "-"+name)
+		} else {
+			names = append(names, "-"+name, "--"+name)
+		}
+	}
+	return names
+}
+
+// explicitBoolFlags rewrites boolean command flags such as "-r" to "-r=true".
+// When reordering a command's flags ahead of its arguments, cli treats the
+// argument following any flag as its value, so without this "add -r docs
+// --glob '*.md' tag" would stop parsing flags at "docs".
+func explicitBoolFlags(app *cli.App, args []string) []string {
+	valueFlags := make(map[string]bool)
+	for _, f := range app.Flags {
+		if _, ok := f.(cli.BoolFlag); !ok {
+			for _, name := range flagNames(f) {
+				valueFlags[name] = true
+			}
+		}
+	}
+
+	result := append([]string{}, args...)
+	for i := 1; i < len(result); i++ {
+		arg := result[i]
+		if strings.HasPrefix(arg, "-") {
+			if valueFlags[arg] {
+				i++
+			}
+			continue
+		}
+
+		command := app.Command(arg)
+		if command == nil {
+			return result
+		}
+		if i+1 < len(result) {
+			for _, sub := range command.Subcommands {
+				if sub.HasName(result[i+1]) {
+					command = &sub
+					i++
+					break
+				}
+			}
+		}
+
+		boolFlags := make(map[string]bool)
+		for _, f := range command.Flags {
+			if _, ok := f.(cli.BoolFlag); ok {
+				for _, name := range flagNames(f) {
+					boolFlags[name] = true
+				}
+			}
+		}
+		for j := i + 1; j < len(result) && result[j] != "--"; j++ {
+			if boolFlags[result[j]] {
+				result[j] += "=true"
+			}
+		}
+		return result
+	}
+
+	return result
+}
+
 func main() {
 	app := newCliApp()
-	err := app.Run(os.Args)
+	err := app.Run(explicitBoolFlags(app, os.Args))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -0,0 +1,174 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// runCommand runs the app with args, which name a command after any
+// global flags, handing the command's context to action in place of its
+// own.
+func runCommand(action func(c *cli.Context) error, args ...string) error {
+	app := newCliApp()
+	app.Writer = ioutil.Discard
+	for i := range app.Commands {
+		app.Commands[i].Action = action
+	}
+	return app.Run(explicitBoolFlags(app, append([]string{AppName}, args...)))
+}
+
+var _ = Describe("main", func() {
+
+	Describe("explicitBoolFlags", func() {
+
+		DescribeTable("should rewrite the command's boolean flags",
+			func(args, expected []string) {
+				Expect(explicitBoolFlags(newCliApp(), args)).To(Equal(expected))
+			},
+			Entry("before an argument",
+				[]string{"ftag", "add", "-r", "docs", "--glob", "*.md", "tag"},
+				[]string{"ftag", "add", "-r=true", "docs", "--glob", "*.md", "tag"}),
+			Entry("after a global flag's value",
+				[]string{"ftag", "--store", "json", "add", "--recursive", "docs", "tag"},
+				[]string{"ftag", "--store", "json", "add", "--recursive=true", "docs", "tag"}),
+			Entry("of a subcommand",
+				[]string{"ftag", "tag", "rename", "--merge", "a", "b"},
+				[]string{"ftag", "tag", "rename", "--merge=true", "a", "b"}),
+			Entry("but not after --",
+				[]string{"ftag", "add", "--", "-r", "tag"},
+				[]string{"ftag", "add", "--", "-r", "tag"}),
+			Entry("but not of an unknown command",
+				[]string{"ftag", "nope", "-r"},
+				[]string{"ftag", "nope", "-r"}),
+			Entry("but not a flag another command defines",
+				[]string{"ftag", "find", "-r", "tag"},
+				[]string{"ftag", "find", "-r", "tag"}),
+		)
+
+		It("should not change its argument", func() {
+			args := []string{"ftag", "add", "-r", "docs", "tag"}
+			explicitBoolFlags(newCliApp(), args)
+			Expect(args[2]).To(Equal("-r"))
+		})
+
+		It("should let flags follow arguments", func() {
+			var recursive bool
+			var glob string
+			Expect(runCommand(func(c *cli.Context) error {
+				recursive = c.Bool(optRecursiveLong)
+				glob = c.String(optGlobLong)
+				return nil
+			}, "add", "docs", "-r", "--glob", "*.md", "tag")).To(Succeed())
+			Expect(recursive).To(BeTrue())
+			Expect(glob).To(Equal("*.md"))
+		})
+
+	})
+
+	Describe("getFilesAndTags", func() {
+
+		getFilesAndTags := func(args ...string) ([]string, []string, error) {
+			var files, tags []string
+			err := runCommand(func(c *cli.Context) error {
+				var err error
+				files, tags, err = getFilesAndTags(c, c.StringSlice(optTagLong))
+				return err
+			}, append([]string{"add"}, args...)...)
+			return files, tags, err
+		}
+
+		It("should take a file and then tags", func() {
+			files, tags, err := getFilesAndTags("foo", "t1", "t2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"foo"}))
+			Expect(tags).To(Equal([]string{"t1", "t2"}))
+		})
+
+		It("should take files when tags are given with --tag", func() {
+			files, tags, err := getFilesAndTags("-t", "t1", "-t", "t2", "foo", "bar")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"foo", "bar"}))
+			Expect(tags).To(Equal([]string{"t1", "t2"}))
+		})
+
+		It("should read files from standard input", func() {
+			stdin := os.Stdin
+			defer func() {
+				os.Stdin = stdin
+			}()
+			f, err := ioutil.TempFile("", "ftag-test")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.Remove(f.Name())
+			_, err = f.WriteString("foo\nbar baz\n")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = f.Seek(0, 0)
+			Expect(err).ToNot(HaveOccurred())
+			os.Stdin = f
+
+			files, tags, err := getFilesAndTags("--stdin", "t1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"foo", "bar baz"}))
+			Expect(tags).To(Equal([]string{"t1"}))
+		})
+
+		DescribeTable("should require files and tags",
+			func(expected string, args ...string) {
+				_, _, err := getFilesAndTags(args...)
+				Expect(err).To(MatchError(expected))
+			},
+			Entry("with no arguments", "must supply a file argument"),
+			Entry("with only a file", "must supply a tag", "foo"),
+			Entry("with --tag and no file", "must supply a file argument", "-t", "t1"),
+			Entry("with --stdin and no tag", "must supply a tag", "--stdin"),
+		)
+
+	})
+
+	Describe("convert", func() {
+
+		var cwd string
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			cwd, err = os.Getwd()
+			Expect(err).ToNot(HaveOccurred())
+			dir, err = ioutil.TempDir("", "ftag-test")
+			Expect(err).ToNot(HaveOccurred())
+			dir, err = filepath.EvalSymlinks(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.Chdir(dir)).To(Succeed())
+
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			Expect(tagmap.NewJSONFileStore(filepath.Join(dir, ".ftag")).Put(tm)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.Chdir(cwd)
+			os.RemoveAll(dir)
+		})
+
+		It("should write the new tag map to --dest", func() {
+			Expect(runCommand(commandConvert, "--output", "json", "convert", "--dest", "tags.db")).To(Succeed())
+
+			tm, err := tagmap.NewSQLiteStore(filepath.Join(dir, "tags.db")).Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1"}))
+		})
+
+		It("should not take --output as the destination", func() {
+			Expect(runCommand(commandConvert, "convert", "--output", "json")).ToNot(Succeed())
+			_, err := os.Stat(filepath.Join(dir, "json"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+	})
+
+})
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	outputText  = "text"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+	outputTSV   = "tsv"
+	outputNull  = "null"
+)
+
+var outputFormats = []string{outputText, outputJSON, outputJSONL, outputCSV, outputTSV, outputNull}
+
+// record is a result of a read command. Its name alone is written for text
+// and null output, and its columns for csv and tsv.
+type record interface {
+	name() string
+	columns() []string
+}
+
+type fileRecord struct {
+	File string   `json:"file"`
+	Tags []string `json:"tags"`
+}
+
+var fileColumns = []string{"file", "tags"}
+
+func (r fileRecord) name() string {
+	return r.File
+}
+
+func (r fileRecord) columns() []string {
+	return []string{r.File, strings.Join(r.Tags, ",")}
+}
+
+type tagRecord struct {
+	Tag   string `json:"tag"`
+	Files int    `json:"files"`
+}
+
+var tagColumns = []string{"tag", "files"}
+
+func (r tagRecord) name() string {
+	return r.Tag
+}
+
+func (r tagRecord) columns() []string {
+	return []string{r.Tag, strconv.Itoa(r.Files)}
+}
+
+// getOutputFormat returns the --output format, or null when -0 is given.
+func getOutputFormat(c *cli.Context) (string, error) {
+	if c.Bool(optNullLong) {
+		return outputNull, nil
+	}
+
+	format := c.GlobalString(optOutputLong)
+	for _, f := range outputFormats {
+		if format == f {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output format: %q, expected one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+func writeRecords(w io.Writer, format string, header []string, records []record) error {
+	switch format {
+	case outputText, outputNull:
+		end := "\n"
+		if format == outputNull {
+			end = "\x00"
+		}
+		for _, r := range records {
+			if _, err := io.WriteString(w, r.name()+end); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case outputJSON:
+		b, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(b, '\n'))
+		return err
+
+	case outputJSONL:
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case outputCSV, outputTSV:
+		cw := csv.NewWriter(w)
+		if format == outputTSV {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		for _, r := range records {
+			if err := cw.Write(r.columns()); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	return fmt.Errorf("unknown output format: %q", format)
+}
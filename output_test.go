@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("writeRecords", func() {
+
+	records := []record{
+		fileRecord{File: "plain.txt", Tags: []string{"a", "b"}},
+		fileRecord{File: `a,b "c".txt`, Tags: []string{"x"}},
+		fileRecord{File: "two\nlines\tand tab.txt", Tags: []string{}},
+	}
+
+	DescribeTable("should quote awkward file names",
+		func(format, expected string) {
+			var buf bytes.Buffer
+			Expect(writeRecords(&buf, format, fileColumns, records)).To(Succeed())
+			Expect(buf.String()).To(Equal(expected))
+		},
+		Entry("as csv", outputCSV, "file,tags\n"+
+			"plain.txt,\"a,b\"\n"+
+			"\"a,b \"\"c\"\".txt\",x\n"+
+			"\"two\nlines\tand tab.txt\",\n"),
+		Entry("as tsv", outputTSV, "file\ttags\n"+
+			"plain.txt\ta,b\n"+
+			"\"a,b \"\"c\"\".txt\"\tx\n"+
+			"\"two\nlines\tand tab.txt\"\t\n"),
+		Entry("as jsonl", outputJSONL, `{"file":"plain.txt","tags":["a","b"]}`+"\n"+
+			`{"file":"a,b \"c\".txt","tags":["x"]}`+"\n"+
+			`{"file":"two\nlines\tand tab.txt","tags":[]}`+"\n"),
+		Entry("as null separated names", outputNull, "plain.txt\x00a,b \"c\".txt\x00two\nlines\tand tab.txt\x00"),
+	)
+
+	It("should write tag counts", func() {
+		var buf bytes.Buffer
+		tags := []record{tagRecord{Tag: "a,b", Files: 2}}
+		Expect(writeRecords(&buf, outputCSV, tagColumns, tags)).To(Succeed())
+		Expect(buf.String()).To(Equal("tag,files\n\"a,b\",2\n"))
+	})
+
+	It("should reject an unknown format", func() {
+		Expect(writeRecords(&bytes.Buffer{}, "xml", fileColumns, records)).To(MatchError(`unknown output format: "xml"`))
+	})
+
+})
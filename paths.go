@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files are keyed in the tag map by their slash-separated path relative
+// to the directory containing the tag map, so that the same file has the
+// same key regardless of the working directory ftag is run from. Files
+// outside that directory can't be keyed, and are refused.
+
+func (ft *FTag) fileKey(file string) (string, error) {
+	p, err := resolvePath(file)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(ft.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the tag map directory %s", file, ft.root)
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+func (ft *FTag) fileKeys(files []string) ([]string, error) {
+	keys := make([]string, len(files))
+	for i, file := range files {
+		key, err := ft.fileKey(file)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+func (ft *FTag) absPath(key string) string {
+	return filepath.Join(ft.root, filepath.FromSlash(key))
+}
+
+func (ft *FTag) displayPath(key string) string {
+	p := ft.absPath(key)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return p
+	}
+
+	rel, err := filepath.Rel(cwd, p)
+	if err != nil {
+		return p
+	}
+	return rel
+}
+
+func (ft *FTag) displayPaths(keys []string) []string {
+	paths := make([]string, len(keys))
+	for i, key := range keys {
+		paths[i] = ft.displayPath(key)
+	}
+	return paths
+}
@@ -0,0 +1,65 @@
+package query
+
+import (
+	"strings"
+)
+
+// JoinArgs joins command line arguments into one expression, keeping each
+// argument that is a plain tag containing spaces, such as "my tag", as one
+// tag. A lone argument that is an operator word, like "or", is a tag too.
+// Arguments holding operators or parentheses are left as they are, so a
+// whole expression can still be passed as one argument.
+func JoinArgs(args []string, isRegexp bool) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		parts[i] = arg
+		if isTag(arg, len(args) == 1, isRegexp) {
+			parts[i] = quoteArg(arg, isRegexp)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// isTag reports whether arg should be read as one tag: it lexes as more
+// than one plain tag and nothing else, or, when alone, as an operator word.
+func isTag(arg string, alone, isRegexp bool) bool {
+	lex := newLexer(arg)
+	lex.raw = isRegexp
+
+	var tokens []token
+	for {
+		tok, err := lex.next()
+		if err != nil {
+			return false
+		}
+		if tok.kind == tokEOF {
+			break
+		}
+		tokens = append(tokens, tok)
+	}
+
+	if len(tokens) == 1 {
+		switch tokens[0].kind {
+		case tokAnd, tokOr, tokNot:
+			return alone && tokens[0].text == strings.TrimSpace(arg) && isWord(tokens[0].text)
+		}
+		return false
+	}
+	for _, tok := range tokens {
+		if tok.kind != tokTag || tok.quoted {
+			return false
+		}
+	}
+	return len(tokens) > 1
+}
+
+func isWord(s string) bool {
+	return !strings.ContainsAny(s, "!&|")
+}
+
+func quoteArg(arg string, isRegexp bool) string {
+	if !isRegexp {
+		arg = strings.ReplaceAll(arg, `\`, `\\`)
+	}
+	return `"` + strings.ReplaceAll(strings.TrimSpace(arg), `"`, `\"`) + `"`
+}
@@ -0,0 +1,69 @@
+package query
+
+import (
+	"github.com/troykinsella/ftag/tagmap"
+	"strconv"
+	"strings"
+)
+
+type Expr interface {
+	String() string
+}
+
+type Tag struct {
+	Name   string
+	Column int
+}
+
+type Attr struct {
+	Key    string
+	Value  interface{}
+	Column int
+}
+
+type Not struct {
+	X Expr
+}
+
+type And struct {
+	Left, Right Expr
+}
+
+type Or struct {
+	Left, Right Expr
+}
+
+func (t *Tag) String() string {
+	if needsQuote(t.Name) {
+		return strconv.Quote(t.Name)
+	}
+	return t.Name
+}
+
+func (a *Attr) String() string {
+	s := tagmap.FormatAttr(a.Key, a.Value)
+	if needsQuote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func (n *Not) String() string {
+	return "not " + n.X.String()
+}
+
+func (a *And) String() string {
+	return "(" + a.Left.String() + " and " + a.Right.String() + ")"
+}
+
+func (o *Or) String() string {
+	return "(" + o.Left.String() + " or " + o.Right.String() + ")"
+}
+
+func needsQuote(name string) bool {
+	switch strings.ToLower(name) {
+	case "and", "or", "not":
+		return true
+	}
+	return strings.ContainsAny(name, " \t\n()!&|\"")
+}
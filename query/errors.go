@@ -0,0 +1,21 @@
+package query
+
+import (
+	"fmt"
+)
+
+type SyntaxError struct {
+	Column int
+	Msg    string
+}
+
+func newSyntaxError(column int, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{
+		Column: column,
+		Msg:    fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d: %s", e.Column, e.Msg)
+}
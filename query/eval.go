@@ -0,0 +1,142 @@
+package query
+
+import (
+	"fmt"
+	"github.com/troykinsella/ftag/tagmap"
+	"sort"
+)
+
+type fileSet map[string]bool
+
+func newFileSet(files []string) fileSet {
+	set := make(fileSet, len(files))
+	for _, f := range files {
+		set[f] = true
+	}
+	return set
+}
+
+type evaluator struct {
+	r     tagmap.Reader
+	tree  *tagmap.TagNode
+	paths []string // every tag in the tree, including ancestors only
+}
+
+// Eval returns the sorted files matching expr. A tag matches files tagged
+// with it or with any of its descendants in the tag hierarchy, and so does
+// a pattern for each tag it matches, failing if it matches none.
+func Eval(expr Expr, r tagmap.Reader) ([]string, error) {
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	ev := &evaluator{
+		r:    r,
+		tree: tagmap.NewTagTree(tags),
+	}
+	ev.tree.Walk(func(node *tagmap.TagNode) {
+		if node != ev.tree {
+			ev.paths = append(ev.paths, node.Tag)
+		}
+	})
+	set, err := ev.eval(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(set))
+	for f := range set {
+		files = append(files, f)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func (ev *evaluator) eval(expr Expr) (fileSet, error) {
+	switch e := expr.(type) {
+	case *Tag:
+		node := ev.tree.Find(e.Name)
+		if node == nil {
+			return make(fileSet), nil
+		}
+		files, err := ev.r.FilesFor(node.Tags()...)
+		if err != nil {
+			return nil, err
+		}
+		return newFileSet(files), nil
+
+	case *Pattern:
+		matched, err := e.MatchTags(ev.paths)
+		if err != nil {
+			return nil, err
+		}
+		tags := []string{}
+		for _, tag := range matched {
+			tags = append(tags, ev.tree.Find(tag).Tags()...)
+		}
+		files, err := ev.r.FilesFor(tags...)
+		if err != nil {
+			return nil, err
+		}
+		return newFileSet(files), nil
+
+	case *Attr:
+		files, err := ev.r.FilesWithAttr(e.Key, e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return newFileSet(files), nil
+
+	case *Not:
+		x, err := ev.eval(e.X)
+		if err != nil {
+			return nil, err
+		}
+		files, err := ev.r.Files()
+		if err != nil {
+			return nil, err
+		}
+		set := make(fileSet)
+		for _, f := range files {
+			if !x[f] {
+				set[f] = true
+			}
+		}
+		return set, nil
+
+	case *And:
+		left, err := ev.eval(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := ev.eval(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		set := make(fileSet)
+		for f := range left {
+			if right[f] {
+				set[f] = true
+			}
+		}
+		return set, nil
+
+	case *Or:
+		set, err := ev.eval(e.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := ev.eval(e.Right)
+		if err != nil {
+			return nil, err
+		}
+		for f := range right {
+			set[f] = true
+		}
+		return set, nil
+	}
+
+	panic(fmt.Sprintf("unknown expression type: %T", expr))
+}
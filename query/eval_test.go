@@ -0,0 +1,92 @@
+package query_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/query"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("Eval", func() {
+
+	var tm *tagmap.TM
+
+	BeforeEach(func() {
+		tm = tagmap.New()
+		tm.Add("a.txt", "draft")
+		tm.Add("b.txt", "review")
+		tm.Add("c.txt", "draft")
+		tm.Add("c.txt", "archived")
+		tm.Add("d.txt", "published")
+	})
+
+	eval := func(input string) []string {
+		e, err := query.Parse(input)
+		Expect(err).ToNot(HaveOccurred())
+		files, err := query.Eval(e, tagmap.NewMapTx(tm))
+		Expect(err).ToNot(HaveOccurred())
+		return files
+	}
+
+	It("should return an empty slice for an unknown tag", func() {
+		files := eval("nope")
+		Expect(files).ToNot(BeNil())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("should find files for a single tag", func() {
+		Expect(eval("draft")).To(Equal([]string{"a.txt", "c.txt"}))
+	})
+
+	It("should intersect with and", func() {
+		Expect(eval("draft archived")).To(Equal([]string{"c.txt"}))
+	})
+
+	It("should union with or", func() {
+		Expect(eval("draft or review")).To(Equal([]string{"a.txt", "b.txt", "c.txt"}))
+	})
+
+	It("should complement with not", func() {
+		Expect(eval("not draft")).To(Equal([]string{"b.txt", "d.txt"}))
+	})
+
+	It("should match descendant tags", func() {
+		tm.Add("e.txt", "project/alpha/design")
+		tm.Add("f.txt", "project/alpha")
+		tm.Add("g.txt", "project/beta")
+		Expect(eval("project/alpha")).To(Equal([]string{"e.txt", "f.txt"}))
+		Expect(eval("project and not project/beta")).To(Equal([]string{"e.txt", "f.txt"}))
+	})
+
+	It("should match attributes", func() {
+		tm.SetAttr("a.txt", "status", "approved")
+		tm.SetAttr("b.txt", "status", "rejected")
+		tm.SetAttr("x.txt", "status", "approved")
+		Expect(eval("status=approved")).To(Equal([]string{"a.txt", "x.txt"}))
+		Expect(eval("not status=approved")).To(Equal([]string{"b.txt", "c.txt", "d.txt"}))
+	})
+
+	It("should match tags by pattern", func() {
+		tm.Add("e.txt", "project/alpha/design")
+		tm.Add("f.txt", "project/beta")
+		Expect(eval("dr* or re*")).To(Equal([]string{"a.txt", "b.txt", "c.txt"}))
+		Expect(eval("project/*")).To(Equal([]string{"e.txt", "f.txt"}))
+		Expect(eval("*/beta")).To(Equal([]string{"f.txt"}))
+
+		e, err := query.ParseRegexp(`"^(pub|arch)"`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(query.Eval(e, tagmap.NewMapTx(tm))).To(Equal([]string{"c.txt", "d.txt"}))
+	})
+
+	It("should fail for a pattern matching no tags", func() {
+		e, err := query.Parse("draft or nope*")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = query.Eval(e, tagmap.NewMapTx(tm))
+		Expect(err).To(MatchError("no tags match nope*"))
+	})
+
+	It("should evaluate compound expressions", func() {
+		Expect(eval("(draft or review) and not archived")).To(Equal([]string{"a.txt", "b.txt"}))
+	})
+
+})
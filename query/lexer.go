@@ -0,0 +1,149 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokTag
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of expression"
+	case tokTag:
+		return "tag"
+	case tokAnd:
+		return "'and'"
+	case tokOr:
+		return "'or'"
+	case tokNot:
+		return "'not'"
+	case tokLParen:
+		return "'('"
+	case tokRParen:
+		return "')'"
+	}
+	return "unknown token"
+}
+
+type token struct {
+	kind   tokenKind
+	text   string
+	pos    int // 1-based column
+	quoted bool
+}
+
+type lexer struct {
+	input []rune
+	i     int
+
+	// raw keeps backslashes in quoted tags other than before a quote, for
+	// regular expressions.
+	raw bool
+}
+
+func newLexer(input string) *lexer {
+	return &lexer{
+		input: []rune(input),
+	}
+}
+
+func isSpecial(r rune) bool {
+	switch r {
+	case '(', ')', '!', '&', '|', '"':
+		return true
+	}
+	return false
+}
+
+func (l *lexer) next() (token, error) {
+	for l.i < len(l.input) && unicode.IsSpace(l.input[l.i]) {
+		l.i++
+	}
+
+	pos := l.i + 1
+	if l.i >= len(l.input) {
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	r := l.input[l.i]
+	switch r {
+	case '(':
+		l.i++
+		return token{kind: tokLParen, text: "(", pos: pos}, nil
+	case ')':
+		l.i++
+		return token{kind: tokRParen, text: ")", pos: pos}, nil
+	case '!':
+		l.i++
+		return token{kind: tokNot, text: "!", pos: pos}, nil
+	case '&', '|':
+		if l.i+1 < len(l.input) && l.input[l.i+1] == r {
+			l.i += 2
+			text := string([]rune{r, r})
+			if r == '&' {
+				return token{kind: tokAnd, text: text, pos: pos}, nil
+			}
+			return token{kind: tokOr, text: text, pos: pos}, nil
+		}
+		return token{}, newSyntaxError(pos, "unexpected character '%c'", r)
+	case '"':
+		return l.quoted()
+	}
+
+	start := l.i
+	for l.i < len(l.input) && !unicode.IsSpace(l.input[l.i]) && !isSpecial(l.input[l.i]) {
+		l.i++
+	}
+	text := string(l.input[start:l.i])
+
+	switch strings.ToLower(text) {
+	case "and":
+		return token{kind: tokAnd, text: text, pos: pos}, nil
+	case "or":
+		return token{kind: tokOr, text: text, pos: pos}, nil
+	case "not":
+		return token{kind: tokNot, text: text, pos: pos}, nil
+	}
+
+	return token{kind: tokTag, text: text, pos: pos}, nil
+}
+
+func (l *lexer) quoted() (token, error) {
+	pos := l.i + 1
+	l.i++ // opening quote
+
+	var sb strings.Builder
+	for l.i < len(l.input) {
+		r := l.input[l.i]
+		switch r {
+		case '\\':
+			if l.i+1 < len(l.input) && (!l.raw || l.input[l.i+1] == '"') {
+				sb.WriteRune(l.input[l.i+1])
+				l.i += 2
+				continue
+			}
+		case '"':
+			l.i++
+			if sb.Len() == 0 {
+				return token{}, newSyntaxError(pos, "empty quoted tag")
+			}
+			return token{kind: tokTag, text: sb.String(), pos: pos, quoted: true}, nil
+		}
+		sb.WriteRune(r)
+		l.i++
+	}
+
+	return token{}, newSyntaxError(pos, "unterminated quoted tag")
+}
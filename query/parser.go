@@ -0,0 +1,191 @@
+package query
+
+import (
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+// Grammar:
+//
+//   expr    = or
+//   or      = and { ( "or" | "||" ) and }
+//   and     = unary { [ "and" | "&&" ] unary }
+//   unary   = ( "not" | "!" ) unary | primary
+//   primary = tag | attr | "(" expr ")"
+//   attr    = key "=" value
+//
+// Adjacent terms without an operator are implicitly joined with "and". An
+// unquoted tag containing glob metacharacters is a Pattern.
+
+type parser struct {
+	lex    *lexer
+	tok    token
+	regexp bool
+}
+
+func Parse(input string) (Expr, error) {
+	return parse(input, false)
+}
+
+// ParseRegexp parses an expression whose tags are all regular expressions.
+func ParseRegexp(input string) (Expr, error) {
+	return parse(input, true)
+}
+
+func parse(input string, isRegexp bool) (Expr, error) {
+	p := &parser{
+		lex:    newLexer(input),
+		regexp: isRegexp,
+	}
+	p.lex.raw = isRegexp
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokEOF {
+		return nil, newSyntaxError(p.tok.pos, "empty expression")
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected()
+	}
+
+	return expr, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return newSyntaxError(p.tok.pos, "unexpected end of expression")
+	}
+	if p.tok.kind == tokRParen {
+		return newSyntaxError(p.tok.pos, "unmatched ')'")
+	}
+	return newSyntaxError(p.tok.pos, "unexpected %s", p.tok.kind)
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.tok.kind == tokOr {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.tok.kind {
+		case tokAnd:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case tokTag, tokNot, tokLParen:
+			// implicit and
+		default:
+			return left, nil
+		}
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.tok.kind == tokNot {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	switch p.tok.kind {
+	case tokTag:
+		var x Expr = &Tag{Name: p.tok.text, Column: p.tok.pos}
+		if tagmap.IsAttr(p.tok.text) {
+			key, value, err := tagmap.ParseAttr(p.tok.text)
+			if err != nil {
+				return nil, newSyntaxError(p.tok.pos, "%s", err)
+			}
+			x = &Attr{Key: key, Value: value, Column: p.tok.pos}
+		} else if p.regexp || (!p.tok.quoted && IsGlob(p.tok.text)) {
+			pattern, err := NewPattern(p.tok.text, p.regexp)
+			if err != nil {
+				return nil, newSyntaxError(p.tok.pos, "%s", err)
+			}
+			pattern.Column = p.tok.pos
+			x = pattern
+		}
+
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return x, nil
+
+	case tokLParen:
+		open := p.tok
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokRParen {
+			return nil, newSyntaxError(p.tok.pos, "empty parentheses")
+		}
+
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind != tokRParen {
+			if p.tok.kind == tokEOF {
+				return nil, newSyntaxError(open.pos, "unmatched '('")
+			}
+			return nil, p.unexpected()
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+
+	return nil, p.unexpected()
+}
@@ -0,0 +1,185 @@
+package query_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/query"
+)
+
+var _ = Describe("Parse", func() {
+
+	parse := func(input string) string {
+		e, err := query.Parse(input)
+		Expect(err).ToNot(HaveOccurred())
+		return e.String()
+	}
+
+	syntaxError := func(input string) *query.SyntaxError {
+		_, err := query.Parse(input)
+		Expect(err).To(HaveOccurred())
+		se, ok := err.(*query.SyntaxError)
+		Expect(ok).To(BeTrue())
+		return se
+	}
+
+	It("should parse a single tag", func() {
+		Expect(parse("foo")).To(Equal("foo"))
+	})
+
+	It("should join adjacent tags with and", func() {
+		Expect(parse("foo bar baz")).To(Equal("((foo and bar) and baz)"))
+	})
+
+	It("should bind and tighter than or", func() {
+		Expect(parse("a or b and c")).To(Equal("(a or (b and c))"))
+		Expect(parse("a and b or c")).To(Equal("((a and b) or c)"))
+	})
+
+	It("should honour parentheses", func() {
+		Expect(parse("(draft or review) and not archived")).To(Equal("((draft or review) and not archived)"))
+	})
+
+	It("should accept symbolic operators", func() {
+		Expect(parse("a && !b || c")).To(Equal("((a and not b) or c)"))
+	})
+
+	It("should treat operators case-insensitively", func() {
+		Expect(parse("a AND NOT b Or c")).To(Equal("((a and not b) or c)"))
+	})
+
+	It("should parse quoted tags", func() {
+		Expect(parse(`"and" or "with space"`)).To(Equal(`("and" or "with space")`))
+	})
+
+	It("should parse attributes", func() {
+		e, err := query.Parse("status=approved and rev=2")
+		Expect(err).ToNot(HaveOccurred())
+		and := e.(*query.And)
+		Expect(and.Left).To(Equal(&query.Attr{Key: "status", Value: "approved", Column: 1}))
+		Expect(and.Right).To(Equal(&query.Attr{Key: "rev", Value: float64(2), Column: 21}))
+	})
+
+	It("should parse unquoted globs as patterns", func() {
+		e, err := query.Parse(`proj-* or "tmp*"`)
+		Expect(err).ToNot(HaveOccurred())
+		or := e.(*query.Or)
+		Expect(or.Left).To(Equal(&query.Pattern{Text: "proj-*", Column: 1}))
+		Expect(or.Right).To(Equal(&query.Tag{Name: "tmp*", Column: 11}))
+	})
+
+	It("should parse every tag as a regular expression", func() {
+		e, err := query.ParseRegexp(`^v[0-9]+$ and not "^(a|b)$"`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.String()).To(Equal(`("^v[0-9]+$" and not "^(a|b)$")`))
+		and := e.(*query.And)
+		Expect(and.Left.(*query.Pattern).Match("v12")).To(BeTrue())
+		Expect(and.Left.(*query.Pattern).Match("v1.2")).To(BeFalse())
+	})
+
+	It("should keep backslashes in quoted regular expressions", func() {
+		e, err := query.ParseRegexp(`"^v\d+\.\"x\"$"`)
+		Expect(err).ToNot(HaveOccurred())
+		p := e.(*query.Pattern)
+		Expect(p.Text).To(Equal(`^v\d+\."x"$`))
+		Expect(p.Match(`v12."x"`)).To(BeTrue())
+		Expect(p.Match(`v12a"x"`)).To(BeFalse())
+
+		again, err := query.ParseRegexp(p.String())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(again.(*query.Pattern).Text).To(Equal(p.Text))
+	})
+
+	It("should report an invalid pattern", func() {
+		se := syntaxError("a proj-[")
+		Expect(se.Column).To(Equal(3))
+
+		_, err := query.ParseRegexp("a (")
+		Expect(err).To(HaveOccurred())
+		_, err = query.ParseRegexp(`"v("`)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should report an attribute without a key", func() {
+		se := syntaxError("a =b")
+		Expect(se.Column).To(Equal(3))
+	})
+
+	It("should report an empty expression", func() {
+		se := syntaxError("   ")
+		Expect(se.Column).To(Equal(4))
+		Expect(se.Msg).To(Equal("empty expression"))
+	})
+
+	It("should report a dangling operator", func() {
+		se := syntaxError("foo and")
+		Expect(se.Column).To(Equal(8))
+		Expect(se.Msg).To(Equal("unexpected end of expression"))
+	})
+
+	It("should report a leading operator", func() {
+		se := syntaxError("or foo")
+		Expect(se.Column).To(Equal(1))
+		Expect(se.Msg).To(Equal("unexpected 'or'"))
+	})
+
+	It("should report an unmatched open parenthesis", func() {
+		se := syntaxError("a and (b or c")
+		Expect(se.Column).To(Equal(7))
+		Expect(se.Msg).To(Equal("unmatched '('"))
+	})
+
+	It("should report an unmatched close parenthesis", func() {
+		se := syntaxError("a or b)")
+		Expect(se.Column).To(Equal(7))
+		Expect(se.Msg).To(Equal("unmatched ')'"))
+	})
+
+	It("should report empty parentheses", func() {
+		se := syntaxError("a ()")
+		Expect(se.Column).To(Equal(4))
+	})
+
+	It("should report an unterminated quote", func() {
+		se := syntaxError(`a "b`)
+		Expect(se.Column).To(Equal(3))
+		Expect(se.Msg).To(Equal("unterminated quoted tag"))
+	})
+
+	It("should report a lone ampersand", func() {
+		se := syntaxError("a & b")
+		Expect(se.Column).To(Equal(3))
+	})
+
+	It("should format errors with the column", func() {
+		_, err := query.Parse("a and")
+		Expect(err.Error()).To(Equal("syntax error at column 6: unexpected end of expression"))
+	})
+
+	Describe("JoinArgs", func() {
+
+		It("should keep an argument with spaces as one tag", func() {
+			expr := query.JoinArgs([]string{"my tag", "or", "other"}, false)
+			Expect(parse(expr)).To(Equal(`("my tag" or other)`))
+		})
+
+		It("should read a lone operator word as a tag", func() {
+			Expect(parse(query.JoinArgs([]string{"or"}, false))).To(Equal(`"or"`))
+			Expect(parse(query.JoinArgs([]string{"a", "or", "b"}, false))).To(Equal("(a or b)"))
+		})
+
+		It("should leave a whole expression as it is", func() {
+			expr := query.JoinArgs([]string{"(draft or review) and not archived"}, false)
+			Expect(parse(expr)).To(Equal("((draft or review) and not archived)"))
+		})
+
+		It("should keep backslashes", func() {
+			Expect(parse(query.JoinArgs([]string{`a\b c`}, false))).To(Equal(`"a\\b c"`))
+
+			e, err := query.ParseRegexp(query.JoinArgs([]string{`^\d+ x$`}, true))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(e.(*query.Pattern).Match("12 x")).To(BeTrue())
+		})
+
+	})
+
+})
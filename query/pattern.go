@@ -0,0 +1,72 @@
+package query
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Pattern matches the tags whose names match a glob, as with path.Match
+// so that '*' does not cross a tag separator, or a regular expression.
+type Pattern struct {
+	Text   string
+	Regexp *regexp.Regexp // nil for a glob
+	Column int
+}
+
+// IsGlob reports whether a tag contains glob metacharacters.
+func IsGlob(tag string) bool {
+	return strings.ContainsAny(tag, "*?[")
+}
+
+func NewPattern(text string, isRegexp bool) (*Pattern, error) {
+	p := &Pattern{Text: text}
+	if isRegexp {
+		re, err := regexp.Compile(text)
+		if err != nil {
+			return nil, err
+		}
+		p.Regexp = re
+		return p, nil
+	}
+
+	if _, err := path.Match(text, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", text, err)
+	}
+	return p, nil
+}
+
+func (p *Pattern) Match(tag string) bool {
+	if p.Regexp != nil {
+		return p.Regexp.MatchString(tag)
+	}
+	ok, _ := path.Match(p.Text, tag)
+	return ok
+}
+
+// MatchTags returns those of tags that match, or an error if none do.
+func (p *Pattern) MatchTags(tags []string) ([]string, error) {
+	matched := []string{}
+	for _, tag := range tags {
+		if p.Match(tag) {
+			matched = append(matched, tag)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no tags match %s", p)
+	}
+	return matched, nil
+}
+
+func (p *Pattern) String() string {
+	if p.Regexp != nil {
+		// Quoted as ParseRegexp reads it, escaping only quotes.
+		return `"` + strings.ReplaceAll(p.Text, `"`, `\"`) + `"`
+	}
+	if needsQuote(p.Text) {
+		return strconv.Quote(p.Text)
+	}
+	return p.Text
+}
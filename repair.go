@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type RepairStatus int
+
+const (
+	RepairMoved RepairStatus = iota
+	RepairAmbiguous
+	RepairUnmatched
+	RepairNoFingerprint
+)
+
+type RepairResult struct {
+	Status     RepairStatus
+	From       string
+	To         string   // for RepairMoved
+	Candidates []string // for RepairAmbiguous
+}
+
+// Repair finds entries whose files are missing and looks for untracked
+// files under the tag map directory with the same content. An entry with a
+// single match is moved to it, unless dryRun is set. Files that can't be
+// read are skipped.
+func (ft *FTag) Repair(dryRun bool) ([]RepairResult, error) {
+	orphans, err := ft.orphans()
+	if err != nil {
+		return nil, err
+	}
+	if len(orphans) == 0 {
+		return []RepairResult{}, nil
+	}
+
+	bySize, err := ft.untrackedFilesBySize()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	hashOf := func(key string) (string, error) {
+		if h, ok := hashes[key]; ok {
+			return h, nil
+		}
+		h, err := hashFile(ft.absPath(key))
+		if err != nil {
+			// Remembered as matching nothing, to be reported once.
+			hashes[key] = ""
+			return "", err
+		}
+		hashes[key] = h
+		return h, nil
+	}
+
+	results := make([]RepairResult, 0, len(orphans))
+	for _, orphan := range orphans {
+		fp, ok, err := ft.tx.Fingerprint(orphan)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			results = append(results, RepairResult{Status: RepairNoFingerprint, From: orphan})
+			continue
+		}
+
+		matches := []string{}
+		for _, candidate := range bySize[fp.Size] {
+			h, err := hashOf(candidate)
+			if err != nil {
+				ft.skip(err)
+				continue
+			}
+			if h == fp.Hash {
+				matches = append(matches, candidate)
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			results = append(results, RepairResult{Status: RepairUnmatched, From: orphan})
+		case 1:
+			results = append(results, RepairResult{Status: RepairMoved, From: orphan, To: matches[0]})
+		default:
+			results = append(results, RepairResult{Status: RepairAmbiguous, From: orphan, Candidates: matches})
+		}
+	}
+
+	// Two orphans with identical content can't both claim the same file.
+	claims := make(map[string]int)
+	for _, r := range results {
+		if r.Status == RepairMoved {
+			claims[r.To]++
+		}
+	}
+	for i, r := range results {
+		if r.Status == RepairMoved && claims[r.To] > 1 {
+			results[i] = RepairResult{Status: RepairAmbiguous, From: r.From, Candidates: []string{r.To}}
+		}
+	}
+
+	if !dryRun {
+		for _, r := range results {
+			if r.Status == RepairMoved {
+				if _, err := ft.tx.Move(r.From, r.To); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	for i := range results {
+		results[i].From = ft.displayPath(results[i].From)
+		if results[i].To != "" {
+			results[i].To = ft.displayPath(results[i].To)
+		}
+		for j, c := range results[i].Candidates {
+			results[i].Candidates[j] = ft.displayPath(c)
+		}
+	}
+
+	return results, nil
+}
+
+func (ft *FTag) orphans() ([]string, error) {
+	files, err := ft.tx.Files()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	orphans := []string{}
+	for _, file := range files {
+		if _, err := os.Lstat(ft.absPath(file)); os.IsNotExist(err) {
+			orphans = append(orphans, file)
+		}
+	}
+	return orphans, nil
+}
+
+func (ft *FTag) untrackedFilesBySize() (map[int64][]string, error) {
+	bySize := make(map[int64][]string)
+
+	err := filepath.Walk(ft.root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if p == ft.root {
+				return err
+			}
+			ft.skip(err)
+			return nil
+		}
+		if fi.IsDir() {
+			if p != ft.root && fi.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(ft.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		has, err := ft.tx.Has(key)
+		if err != nil {
+			return err
+		}
+		if has {
+			return nil
+		}
+
+		bySize[fi.Size()] = append(bySize[fi.Size()], key)
+		return nil
+	})
+
+	return bySize, err
+}
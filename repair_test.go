@@ -0,0 +1,83 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+var _ = Describe("Repair", func() {
+
+	var cwd string
+	var dir string
+	var ftag *FTag
+	var skipped []error
+
+	BeforeEach(func() {
+		var err error
+		cwd, err = os.Getwd()
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = filepath.EvalSymlinks(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Chdir(dir)).To(Succeed())
+
+		skipped = nil
+		ftag = New(tagmap.NewJSONFileStore(filepath.Join(dir, ".ftag")), dir)
+		ftag.onSkip = func(err error) {
+			skipped = append(skipped, err)
+		}
+	})
+
+	AfterEach(func() {
+		os.Chmod(filepath.Join(dir, "locked"), 0755)
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	})
+
+	repair := func() []RepairResult {
+		var results []RepairResult
+		Expect(ftag.Update("repair", func() error {
+			var err error
+			results, err = ftag.Repair(false)
+			return err
+		})).To(Succeed())
+		return results
+	}
+
+	It("should move an entry to its file's new name", func() {
+		Expect(ioutil.WriteFile("a", []byte("content"), 0644)).To(Succeed())
+		Expect(ftag.Update("add", func() error {
+			return ftag.Add("a", "t1")
+		})).To(Succeed())
+		Expect(os.Rename("a", "b")).To(Succeed())
+
+		Expect(repair()).To(Equal([]RepairResult{{Status: RepairMoved, From: "a", To: "b"}}))
+		Expect(skipped).To(BeEmpty())
+	})
+
+	It("should skip and report files it can't read", func() {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			Skip("file permissions are not enforced")
+		}
+		Expect(ioutil.WriteFile("a", []byte("content"), 0644)).To(Succeed())
+		Expect(ftag.Update("add", func() error {
+			return ftag.Add("a", "t1")
+		})).To(Succeed())
+		Expect(os.Rename("a", "b")).To(Succeed())
+
+		Expect(ioutil.WriteFile("unreadable", []byte("content"), 0)).To(Succeed())
+		Expect(os.Mkdir("locked", 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join("locked", "c"), []byte("content"), 0644)).To(Succeed())
+		Expect(os.Chmod("locked", 0)).To(Succeed())
+
+		Expect(repair()).To(Equal([]RepairResult{{Status: RepairMoved, From: "a", To: "b"}}))
+		Expect(skipped).To(HaveLen(2))
+	})
+
+})
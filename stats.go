@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/troykinsella/ftag/tagmap"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+)
+
+type statsReport struct {
+	*tagmap.Stats
+
+	Size     int64    `json:"size,omitempty"`
+	Untagged []string `json:"untagged"`
+	Missing  []string `json:"missing"`
+}
+
+// Stats reports on the tag map and the files under its root directory,
+// skipping the tag map's own files.
+func (ft *FTag) Stats(top int, own *tagMapFiles) (*statsReport, error) {
+	stats, err := tagmap.CountStats(ft.tx, top)
+	if err != nil {
+		return nil, err
+	}
+	report := &statsReport{Stats: stats}
+
+	report.Untagged, err = ft.Untagged([]string{ft.root}, own)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Missing, err = ft.Missing()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(own.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if fi.Mode().IsRegular() && own.isMapFile(fi.Name()) {
+			report.Size += fi.Size()
+		}
+	}
+
+	return report, nil
+}
+
+// Untagged lists the files under dirs that are not in the tag map, other
+// than the tag map's own files.
+func (ft *FTag) Untagged(dirs []string, own *tagMapFiles) ([]string, error) {
+	files, err := expandPaths(dirs, true, "", own)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			return nil, err
+		}
+		p, err := resolvePath(f)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ft.fileKey(p)
+		if err != nil {
+			return nil, err
+		}
+		has, err := ft.tx.Has(key)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			result = append(result, ft.displayPath(key))
+		}
+	}
+	return result, nil
+}
+
+// Missing lists the files in the tag map that no longer exist.
+func (ft *FTag) Missing() ([]string, error) {
+	keys, err := ft.tx.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, key := range keys {
+		if _, err := os.Stat(ft.absPath(key)); os.IsNotExist(err) {
+			result = append(result, ft.displayPath(key))
+		}
+	}
+	return result, nil
+}
+
+func commandStats(c *cli.Context) error {
+	format, err := getOutputFormat(c)
+	if err != nil {
+		return err
+	}
+	if format != outputText && format != outputJSON && format != outputJSONL {
+		return fmt.Errorf("%s output is not supported by stats", format)
+	}
+
+	return viewFTag(c, func(ftag *FTag) error {
+		own, err := getTagMapFiles(c)
+		if err != nil {
+			return err
+		}
+
+		report, err := ftag.Stats(c.Int(optTopLong), own)
+		if err != nil {
+			return err
+		}
+
+		if format != outputText {
+			enc := json.NewEncoder(os.Stdout)
+			if format == outputJSON {
+				enc.SetIndent("", "  ")
+			}
+			return enc.Encode(report)
+		}
+
+		printStats(report)
+		return nil
+	})
+}
+
+func printStats(report *statsReport) {
+	fmt.Printf("Files:      %d\n", report.Files)
+	fmt.Printf("Tags:       %d\n", report.Tags)
+	fmt.Printf("Attributes: %d\n", report.Attrs)
+	if report.Size > 0 {
+		fmt.Printf("Size:       %d bytes\n", report.Size)
+	}
+
+	if len(report.TagCounts) > 0 {
+		fmt.Println("\nFiles per tag:")
+		for _, tc := range report.TagCounts {
+			fmt.Printf("%8d  %s\n", tc.Files, tc.Tag)
+		}
+	}
+
+	if len(report.TagPairs) > 0 {
+		fmt.Println("\nTags most often together:")
+		for _, tp := range report.TagPairs {
+			fmt.Printf("%8d  %s, %s\n", tp.Files, tp.Tags[0], tp.Tags[1])
+		}
+	}
+
+	if len(report.Untagged) > 0 {
+		fmt.Printf("\nUntagged files (%d):\n", len(report.Untagged))
+		for _, f := range report.Untagged {
+			fmt.Println("  " + f)
+		}
+	}
+
+	if len(report.Missing) > 0 {
+		fmt.Printf("\nMissing files (%d):\n", len(report.Missing))
+		for _, f := range report.Missing {
+			fmt.Println("  " + f)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/urfave/cli"
+	"io"
+	"os"
+)
+
+const stdinArg = "-"
+
+func stdinFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  optStdinLong,
+			Usage: "Also read file names from standard input, one per line (same as a '" + stdinArg + "' file argument)",
+		},
+		nullFlag,
+	}
+}
+
+var nullFlag = cli.BoolFlag{
+	Name:  optNull + ", " + optNullLong,
+	Usage: "Separate the names read from standard input or written to standard output with NUL characters, as with 'find -print0' and 'xargs -0'",
+}
+
+// readFileList reads file names separated by newlines, or by NULs when
+// null is set, skipping empty names.
+func readFileList(r io.Reader, null bool) ([]string, error) {
+	sep := byte('\n')
+	if null {
+		sep = 0
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	files := []string{}
+	for scanner.Scan() {
+		file := scanner.Text()
+		if !null {
+			file = string(bytes.TrimSuffix([]byte(file), []byte("\r")))
+		}
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// getFileList replaces a '-' in files, or adds when --stdin is set, the
+// file names read from standard input. fromStdin reports whether they were
+// read, so that an empty list can be told apart from no file arguments.
+func getFileList(c *cli.Context, files []string) (result []string, fromStdin bool, err error) {
+	fromStdin = c.Bool(optStdinLong)
+
+	result = []string{}
+	for _, f := range files {
+		if f == stdinArg {
+			fromStdin = true
+			continue
+		}
+		result = append(result, f)
+	}
+
+	if fromStdin {
+		list, err := readFileList(os.Stdin, c.Bool(optNullLong))
+		if err != nil {
+			return nil, false, err
+		}
+		result = append(result, list...)
+	}
+
+	return result, fromStdin, nil
+}
+
+// updateEach applies fn to each file in a single update of the tag map,
+// reporting files it fails for without stopping. If before is given, it is
+// run first and its failure abandons the update.
+func updateEach(c *cli.Context, files []string, before func(ftag *FTag) error, fn func(ftag *FTag, file string) error) error {
+	failed := 0
+	err := updateFTag(c, func(ftag *FTag) error {
+		if before != nil {
+			if err := before(ftag); err != nil {
+				return err
+			}
+		}
+		for _, f := range files {
+			if err := fn(ftag, f); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", f, err)
+				failed++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed for %d of %d files", failed, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"strings"
+)
+
+var _ = Describe("readFileList", func() {
+
+	DescribeTable("should split file names",
+		func(input string, null bool, expected []string) {
+			Expect(readFileList(strings.NewReader(input), null)).To(Equal(expected))
+		},
+		Entry("on newlines", "a\nb c\n", false, []string{"a", "b c"}),
+		Entry("on NULs", "a\x00b\nc\x00", true, []string{"a", "b\nc"}),
+		Entry("keeping newlines out of NUL separated names", "a\nb", true, []string{"a\nb"}),
+		Entry("stripping CRs from lines", "a\r\nb\r\n", false, []string{"a", "b"}),
+		Entry("keeping CRs in NUL separated names", "a\r\x00", true, []string{"a\r"}),
+		Entry("with a final line with no newline", "a\nb", false, []string{"a", "b"}),
+		Entry("with a final name with no NUL", "a\x00b", true, []string{"a", "b"}),
+		Entry("skipping empty names", "\na\n\n\r\nb\n", false, []string{"a", "b"}),
+		Entry("skipping empty NUL separated names", "\x00a\x00\x00", true, []string{"a"}),
+		Entry("from empty input", "", false, []string{}),
+	)
+
+})
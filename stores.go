@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"github.com/troykinsella/ftag/tagmap"
+	"github.com/urfave/cli"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	storeJSON    = "json"
+	storeJournal = "journal"
+	storeSQLite  = "sqlite"
+	storeXattr   = "xattr"
+
+	defaultTagMap        = ".ftag"
+	defaultJournalTagMap = ".ftag.snapshot"
+	defaultSQLiteTagMap  = ".ftag.db"
+
+	historySuffix = ".history"
+)
+
+var storeKinds = []string{storeJSON, storeJournal, storeSQLite, storeXattr}
+
+func checkStoreKind(kind string) error {
+	for _, k := range storeKinds {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown store: %s (expected one of: %s)", kind, strings.Join(storeKinds, ", "))
+}
+
+// defaultTagMapName is the file name looked for when discovering the tag
+// map of a file-based store.
+func defaultTagMapName(kind string) string {
+	switch kind {
+	case storeJournal:
+		return defaultJournalTagMap
+	case storeSQLite:
+		return defaultSQLiteTagMap
+	}
+	return defaultTagMap
+}
+
+func getTagMapPath(c *cli.Context, kind string) (string, error) {
+	p := c.GlobalString(optTagMap)
+	if p == "" {
+		return findTagMap(defaultTagMapName(kind))
+	}
+
+	p, err := resolvePath(p)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("tag map not found: %s (run '%s init' to create it)", p, AppName)
+		}
+		return "", err
+	}
+
+	return p, nil
+}
+
+// getHistoryPath returns the path of the operation history kept beside the
+// tag map, or in the root directory for the xattr store.
+func getHistoryPath(c *cli.Context, kind, root string) (string, error) {
+	if kind == storeXattr {
+		return filepath.Join(root, defaultTagMap+historySuffix), nil
+	}
+
+	p, err := getTagMapPath(c, kind)
+	if err != nil {
+		return "", err
+	}
+	return p + historySuffix, nil
+}
+
+// tagMapFiles recognizes the files a store keeps for itself in a
+// directory: the tag map with its lock, backup, journal and SQLite files,
+// the history and its lock, and temporary files left while replacing any
+// of them.
+type tagMapFiles struct {
+	dir     string
+	mapName string // empty for the xattr store, which keeps only a history
+}
+
+// getTagMapFiles returns the own files of the tag map in use.
+func getTagMapFiles(c *cli.Context) (*tagMapFiles, error) {
+	kind := c.GlobalString(optStoreLong)
+	if kind == storeXattr {
+		root, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		return &tagMapFiles{dir: root}, nil
+	}
+
+	p, err := getTagMapPath(c, kind)
+	if err != nil {
+		return nil, err
+	}
+	return &tagMapFiles{dir: filepath.Dir(p), mapName: filepath.Base(p)}, nil
+}
+
+// match reports whether the absolute path p is one of the files.
+func (tf *tagMapFiles) match(p string) bool {
+	if filepath.Dir(p) != tf.dir {
+		return false
+	}
+	name := filepath.Base(p)
+	return tf.isMapFile(name) || tf.isHistory(name)
+}
+
+// isMapFile reports whether name is the tag map or a file kept beside it,
+// other than the history.
+func (tf *tagMapFiles) isMapFile(name string) bool {
+	if tf.mapName == "" {
+		return false
+	}
+	name = tempTarget(name)
+
+	m := tf.mapName
+	switch name {
+	case m, m + ".lock", m + ".bak", m + ".journal", m + "-wal", m + "-shm", m + "-journal":
+		return true
+	}
+	return strings.HasPrefix(name, m+".bak.") && isDigits(name[len(m+".bak."):])
+}
+
+// isHistory reports whether name is the history or its lock.
+func (tf *tagMapFiles) isHistory(name string) bool {
+	h := defaultTagMap + historySuffix
+	if tf.mapName != "" {
+		h = tf.mapName + historySuffix
+	}
+	name = tempTarget(name)
+	return name == h || name == h+".lock"
+}
+
+// tempTarget returns the name of the file that a temporary file made while
+// replacing it, named like ".name.tmp123", stands in for, or else name.
+func tempTarget(name string) string {
+	i := strings.LastIndex(name, ".tmp")
+	if strings.HasPrefix(name, ".") && i > 1 && isDigits(name[i+len(".tmp"):]) {
+		return name[1:i]
+	}
+	return name
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func findTagMap(name string) (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	return "", fmt.Errorf("no tag map (%s) found in the current directory or any parent (run '%s init' to create one)", name, AppName)
+}
+
+// newTagMapStore creates a store of the given kind. For file-based stores
+// p is the tag map path, and for the xattr store it is the root directory.
+func newTagMapStore(c *cli.Context, kind, p string) tagmap.Store {
+	switch kind {
+	case storeSQLite:
+		tagMapStore := tagmap.NewSQLiteStore(p)
+		tagMapStore.LockTimeout = c.GlobalDuration(optLockTimeoutLong)
+		return tagMapStore
+
+	case storeXattr:
+		tagMapStore := tagmap.NewXattrStore(p)
+		tagMapStore.OnSkip = reportSkipped
+		return tagMapStore
+
+	case storeJournal:
+		tagMapStore := tagmap.NewJournalStore(p)
+		tagMapStore.CompactAfter = c.GlobalInt(optCompactAfterLong)
+		tagMapStore.Snapshot().LockTimeout = c.GlobalDuration(optLockTimeoutLong)
+		tagMapStore.Snapshot().Backups = c.GlobalInt(optBackupsLong)
+		return tagMapStore
+	}
+
+	tagMapStore := tagmap.NewJSONFileStore(p)
+	tagMapStore.LockTimeout = c.GlobalDuration(optLockTimeoutLong)
+	tagMapStore.Backups = c.GlobalInt(optBackupsLong)
+	return tagMapStore
+}
+
+// openTagMapStore returns an existing store of the given kind and the
+// directory that file names in it are relative to.
+func openTagMapStore(c *cli.Context, kind string) (tagmap.Store, string, error) {
+	err := checkStoreKind(kind)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if kind == storeXattr {
+		root, err := os.Getwd()
+		if err != nil {
+			return nil, "", err
+		}
+		return newTagMapStore(c, kind, root), root, nil
+	}
+
+	tagMapPath, err := getTagMapPath(c, kind)
+	if err != nil {
+		return nil, "", err
+	}
+	return newTagMapStore(c, kind, tagMapPath), filepath.Dir(tagMapPath), nil
+}
+
+// createTagMapStore returns a store of the given kind that must not already
+// exist at p, except for the xattr store where p is the root directory.
+func createTagMapStore(c *cli.Context, kind, p string) (tagmap.Store, error) {
+	if kind != storeXattr {
+		if _, err := os.Stat(p); err == nil {
+			return nil, fmt.Errorf("tag map already exists: %s", p)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return newTagMapStore(c, kind, p), nil
+}
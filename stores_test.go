@@ -0,0 +1,116 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("getTagMapPath", func() {
+
+	var cwd string
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		cwd, err = os.Getwd()
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = filepath.EvalSymlinks(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0755)).To(Succeed())
+		Expect(os.Chdir(filepath.Join(dir, "sub", "deep"))).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.Unsetenv(envTagMap)
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	})
+
+	getTagMapPath := func(args ...string) (string, error) {
+		var p string
+		err := runCommand(func(c *cli.Context) error {
+			var err error
+			p, err = getTagMapPath(c, storeJSON)
+			return err
+		}, append(args, "find")...)
+		return p, err
+	}
+
+	It("should find the tag map in a parent directory", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, ".ftag"), nil, 0644)).To(Succeed())
+		Expect(getTagMapPath()).To(Equal(filepath.Join(dir, ".ftag")))
+	})
+
+	It("should find the nearest tag map", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, ".ftag"), nil, 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "sub", ".ftag"), nil, 0644)).To(Succeed())
+		Expect(getTagMapPath()).To(Equal(filepath.Join(dir, "sub", ".ftag")))
+	})
+
+	It("should fail when there is no tag map", func() {
+		_, err := getTagMapPath()
+		Expect(err).To(MatchError("no tag map (.ftag) found in the current directory or any parent (run 'ftag init' to create one)"))
+	})
+
+	It("should use the tag map named by FTAG_MAP", func() {
+		p := filepath.Join(dir, "tags.json")
+		Expect(ioutil.WriteFile(p, nil, 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, ".ftag"), nil, 0644)).To(Succeed())
+		os.Setenv(envTagMap, p)
+		Expect(getTagMapPath()).To(Equal(p))
+	})
+
+	It("should prefer --tag-map to FTAG_MAP, relative to the current directory", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "sub", "deep", "tags.json"), nil, 0644)).To(Succeed())
+		os.Setenv(envTagMap, filepath.Join(dir, "nope"))
+		Expect(getTagMapPath("-m", "tags.json")).To(Equal(filepath.Join(dir, "sub", "deep", "tags.json")))
+	})
+
+	It("should fail when the named tag map does not exist", func() {
+		p := filepath.Join(dir, "nope")
+		os.Setenv(envTagMap, p)
+		_, err := getTagMapPath()
+		Expect(err).To(MatchError("tag map not found: " + p + " (run 'ftag init' to create it)"))
+	})
+
+})
+
+var _ = Describe("tagMapFiles", func() {
+
+	dir := filepath.FromSlash("/work")
+
+	DescribeTable("should recognize the tag map's own files",
+		func(mapName, name string, expected bool) {
+			tf := &tagMapFiles{dir: dir, mapName: mapName}
+			Expect(tf.match(filepath.Join(dir, name))).To(Equal(expected))
+		},
+		Entry("the map", ".ftag", ".ftag", true),
+		Entry("its lock", ".ftag", ".ftag.lock", true),
+		Entry("a backup", ".ftag", ".ftag.bak", true),
+		Entry("an older backup", ".ftag", ".ftag.bak.3", true),
+		Entry("the history", ".ftag", ".ftag.history", true),
+		Entry("the history lock", ".ftag", ".ftag.history.lock", true),
+		Entry("a temporary map", ".ftag", "..ftag.tmp123456", true),
+		Entry("a temporary history", ".ftag", "..ftag.history.tmp42", true),
+		Entry("the journal", ".ftag.snapshot", ".ftag.snapshot.journal", true),
+		Entry("the SQLite WAL", ".ftag.db", ".ftag.db-wal", true),
+		Entry("the SQLite shared memory", ".ftag.db", ".ftag.db-shm", true),
+		Entry("the SQLite rollback journal", ".ftag.db", ".ftag.db-journal", true),
+		Entry("the history of the xattr store", "", ".ftag.history", true),
+
+		Entry("a file sharing the map's prefix", ".ftag", ".ftagignore", false),
+		Entry("a file in a directory sharing the prefix", ".ftag", ".ftags/notes.md", false),
+		Entry("a map of the same name elsewhere", ".ftag", "sub/.ftag", false),
+		Entry("a backup with a name suffix", ".ftag", ".ftag.bak.old", false),
+		Entry("a file of the xattr store's name", "", ".ftag", false),
+	)
+
+})
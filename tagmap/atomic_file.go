@@ -0,0 +1,129 @@
+package tagmap
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const defaultFileMode os.FileMode = 0644
+
+// writeFileAtomic replaces the file at path with data such that readers,
+// and the file itself after a crash, see either the old or the new
+// content in full. The permissions of an existing file are preserved.
+func writeFileAtomic(path string, data []byte) error {
+	mode := defaultFileMode
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	err = writeAndSync(tmp, data, mode)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+func writeAndSync(f *os.File, data []byte, mode os.FileMode) error {
+	_, err := f.Write(data)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncDir persists a rename in dir. Not all platforms support syncing a
+// directory, so failure is ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+func backupPath(path string, generation int) string {
+	if generation == 1 {
+		return path + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", path, generation)
+}
+
+// rotateBackups shifts existing backups of path back by one generation,
+// dropping the oldest, and snapshots the current file as the newest.
+func rotateBackups(path string, generations int) error {
+	if generations < 1 {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := os.Remove(backupPath(path, generations))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for g := generations - 1; g >= 1; g-- {
+		err := os.Rename(backupPath(path, g), backupPath(path, g+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return copyFile(path, backupPath(path, 1))
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package tagmap
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const AttrSeparator = "="
+
+// Attribute values are typed: a string, a float64 or a bool, matching what
+// encoding/json decodes them to.
+type Attrs map[string]interface{}
+
+func IsAttr(s string) bool {
+	return strings.Contains(s, AttrSeparator)
+}
+
+// ParseAttr splits "key=value" and parses value with ParseAttrValue.
+func ParseAttr(s string) (string, interface{}, error) {
+	i := strings.Index(s, AttrSeparator)
+	if i < 0 {
+		return "", nil, fmt.Errorf("invalid attribute, expected key%svalue: %q", AttrSeparator, s)
+	}
+
+	key := strings.TrimSpace(s[:i])
+	if key == "" {
+		return "", nil, fmt.Errorf("invalid attribute, missing key: %q", s)
+	}
+
+	return key, ParseAttrValue(s[i+1:]), nil
+}
+
+// ParseAttrValue types a value as a bool or number when it is written
+// exactly as one would be formatted, and otherwise leaves it a string.
+func ParseAttrValue(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil && strconv.FormatFloat(f, 'f', -1, 64) == s {
+		return f
+	}
+
+	return s
+}
+
+func FormatAttrValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return fmt.Sprint(v)
+}
+
+func FormatAttr(key string, value interface{}) string {
+	return key + AttrSeparator + FormatAttrValue(value)
+}
+
+func (tm *TM) SetAttr(file, key string, value interface{}) {
+	if tm.FileToAttr == nil {
+		tm.FileToAttr = make(map[string]Attrs)
+	}
+
+	attrs, ok := tm.FileToAttr[file]
+	if !ok {
+		attrs = make(Attrs)
+		tm.FileToAttr[file] = attrs
+	}
+	attrs[key] = value
+}
+
+func (tm *TM) Attr(file, key string) (interface{}, bool) {
+	v, ok := tm.FileToAttr[file][key]
+	return v, ok
+}
+
+func (tm *TM) UnsetAttr(file, key string) bool {
+	attrs, ok := tm.FileToAttr[file]
+	if !ok {
+		return false
+	}
+	if _, ok := attrs[key]; !ok {
+		return false
+	}
+
+	delete(attrs, key)
+	if len(attrs) == 0 {
+		delete(tm.FileToAttr, file)
+	}
+	tm.prune(file)
+	return true
+}
+
+func (tm *TM) FilesWithAttr(key string, value interface{}) []string {
+	files := []string{}
+	for file, attrs := range tm.FileToAttr {
+		if v, ok := attrs[key]; ok && v == value {
+			files = append(files, file)
+		}
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+// Strings lists the attributes as sorted "key=value" strings.
+func (attrs Attrs) Strings() []string {
+	result := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		result = append(result, FormatAttr(k, v))
+	}
+
+	sort.Strings(result)
+	return result
+}
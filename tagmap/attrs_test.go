@@ -0,0 +1,107 @@
+package tagmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("Attrs", func() {
+
+	Describe("ParseAttr", func() {
+
+		It("should split the key and value", func() {
+			k, v, err := tagmap.ParseAttr("status=approved")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k).To(Equal("status"))
+			Expect(v).To(Equal("approved"))
+		})
+
+		It("should only split on the first separator", func() {
+			_, v, err := tagmap.ParseAttr("expr=a=b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal("a=b"))
+		})
+
+		It("should reject a missing key", func() {
+			_, _, err := tagmap.ParseAttr("=foo")
+			Expect(err).To(HaveOccurred())
+		})
+
+	})
+
+	Describe("ParseAttrValue", func() {
+
+		It("should type booleans and numbers", func() {
+			Expect(tagmap.ParseAttrValue("true")).To(Equal(true))
+			Expect(tagmap.ParseAttrValue("false")).To(Equal(false))
+			Expect(tagmap.ParseAttrValue("42")).To(Equal(float64(42)))
+			Expect(tagmap.ParseAttrValue("-1.5")).To(Equal(-1.5))
+		})
+
+		It("should keep values that would not format identically as strings", func() {
+			Expect(tagmap.ParseAttrValue("007")).To(Equal("007"))
+			Expect(tagmap.ParseAttrValue("1e3")).To(Equal("1e3"))
+			Expect(tagmap.ParseAttrValue("True")).To(Equal("True"))
+			Expect(tagmap.ParseAttrValue("")).To(Equal(""))
+		})
+
+	})
+
+	Describe("SetAttr", func() {
+
+		It("should replace the previous value for a key", func() {
+			tm := tagmap.New()
+			tm.SetAttr("foo", "status", "draft")
+			tm.SetAttr("foo", "status", "approved")
+			v, ok := tm.Attr("foo", "status")
+			Expect(ok).To(BeTrue())
+			Expect(v).To(Equal("approved"))
+			Expect(tm.FilesWithAttr("status", "draft")).To(BeEmpty())
+		})
+
+		It("should list files having only attributes", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag")
+			tm.SetAttr("bar", "k", "v")
+			Expect(tm.ListFiles()).To(ConsistOf("foo", "bar"))
+		})
+
+	})
+
+	Describe("UnsetAttr", func() {
+
+		It("should remove the file entry with its last attribute", func() {
+			tm := tagmap.New()
+			tm.SetAttr("foo", "k", "v")
+			Expect(tm.UnsetAttr("foo", "k")).To(BeTrue())
+			Expect(tm.UnsetAttr("foo", "k")).To(BeFalse())
+			Expect(tm.FileToAttr).ToNot(HaveKey("foo"))
+		})
+
+	})
+
+	Describe("FilesWithAttr", func() {
+
+		It("should compare typed values", func() {
+			tm := tagmap.New()
+			tm.SetAttr("a", "rev", float64(2))
+			tm.SetAttr("b", "rev", "2.0")
+			Expect(tm.FilesWithAttr("rev", tagmap.ParseAttrValue("2"))).To(Equal([]string{"a"}))
+		})
+
+	})
+
+	Describe("Clear", func() {
+
+		It("should remove attributes", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag")
+			tm.SetAttr("foo", "k", "v")
+			tm.Clear("foo")
+			Expect(tm.FileToAttr).ToNot(HaveKey("foo"))
+		})
+
+	})
+
+})
@@ -0,0 +1,25 @@
+package tagmap
+
+import (
+	"time"
+)
+
+// Fingerprint identifies a file's content so that its entry can be found
+// again after the file is moved or renamed without telling ftag.
+type Fingerprint struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func (tm *TM) SetFingerprint(file string, fp Fingerprint) {
+	if tm.FileToFingerprint == nil {
+		tm.FileToFingerprint = make(map[string]Fingerprint)
+	}
+	tm.FileToFingerprint[file] = fp
+}
+
+func (tm *TM) Fingerprint(file string) (Fingerprint, bool) {
+	fp, ok := tm.FileToFingerprint[file]
+	return fp, ok
+}
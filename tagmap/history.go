@@ -0,0 +1,396 @@
+package tagmap
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const DefaultHistoryLimit = 100
+
+// FileState is everything the tag map holds for a file. A nil state means
+// the file is not in the map.
+type FileState struct {
+	Tags        []string     `json:"tags,omitempty"`
+	Attrs       Attrs        `json:"attrs,omitempty"`
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+type FileChange struct {
+	File   string     `json:"file"`
+	Before *FileState `json:"before,omitempty"`
+	After  *FileState `json:"after,omitempty"`
+}
+
+// Operation is a recorded change to the tag map made by one command.
+type Operation struct {
+	ID      int          `json:"id"`
+	Time    time.Time    `json:"time"`
+	User    string       `json:"user,omitempty"`
+	Command string       `json:"command"`
+	Changes []FileChange `json:"changes"`
+	Undone  bool         `json:"undone,omitempty"`
+}
+
+// History keeps the most recent operations on a tag map in a file, one
+// JSON operation per line, so they can be listed, undone and redone.
+// Operations are recorded, and marked undone or redone, only once the
+// changes they describe are stored. Changes to the tag map and to its
+// history are made together under the history's own lock, with WithLock.
+type History struct {
+	path string
+
+	User        string
+	Limit       int
+	LockTimeout time.Duration
+}
+
+func NewHistory(path string) *History {
+	if path == "" {
+		panic("path required")
+	}
+
+	return &History{
+		path:        path,
+		User:        currentUser(),
+		Limit:       DefaultHistoryLimit,
+		LockTimeout: DefaultLockTimeout,
+	}
+}
+
+// WithLock runs fn while holding an exclusive lock on the history, so that
+// concurrent updates, including to stores that take no lock of their own,
+// can't lose each other's operations.
+func (h *History) WithLock(fn func() error) error {
+	lock, err := acquireLock(h.path+".lock", true, h.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return fn()
+}
+
+func (h *History) Load() ([]Operation, error) {
+	data, err := ioutil.ReadFile(h.path)
+	if os.IsNotExist(err) {
+		return []Operation{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []Operation{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var op Operation
+		if err := dec.Decode(&op); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (h *History) Save(ops []Operation) error {
+	if h.Limit > 0 && len(ops) > h.Limit {
+		ops = ops[len(ops)-h.Limit:]
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, op := range ops {
+		if err := enc.Encode(op); err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(h.path, buf.Bytes())
+}
+
+// Record adds an operation, discarding any undone operations, which can no
+// longer be redone.
+func (h *History) Record(command string, changes []FileChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	ops, err := h.Load()
+	if err != nil {
+		return err
+	}
+
+	id := 1
+	if len(ops) > 0 {
+		id = ops[len(ops)-1].ID + 1
+	}
+	for len(ops) > 0 && ops[len(ops)-1].Undone {
+		ops = ops[:len(ops)-1]
+	}
+
+	ops = append(ops, Operation{
+		ID:      id,
+		Time:    time.Now().UTC(),
+		User:    h.User,
+		Command: command,
+		Changes: changes,
+	})
+	return h.Save(ops)
+}
+
+// Undo restores the files changed by the last n operations that are not
+// undone, most recent first, and returns those operations. The history is
+// left as it is, so that the operations can be marked undone with
+// SetUndone once the restored files are stored.
+func (h *History) Undo(tx Tx, n int) ([]Operation, error) {
+	ops, err := h.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Undone operations always trail the others.
+	end := len(ops)
+	for end > 0 && ops[end-1].Undone {
+		end--
+	}
+
+	result := []Operation{}
+	for i := end - 1; i >= 0 && len(result) < n; i-- {
+		for _, c := range ops[i].Changes {
+			if err := RestoreFile(tx, c.File, c.Before); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, ops[i])
+	}
+
+	return result, nil
+}
+
+// Redo reapplies the n earliest undone operations, and returns them. As
+// with Undo, they are marked with SetUndone once stored.
+func (h *History) Redo(tx Tx, n int) ([]Operation, error) {
+	ops, err := h.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	start := len(ops)
+	for start > 0 && ops[start-1].Undone {
+		start--
+	}
+
+	result := []Operation{}
+	for i := start; i < len(ops) && len(result) < n; i++ {
+		for _, c := range ops[i].Changes {
+			if err := RestoreFile(tx, c.File, c.After); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, ops[i])
+	}
+
+	return result, nil
+}
+
+// SetUndone marks operations returned by Undo or Redo as undone or not.
+func (h *History) SetUndone(done []Operation, undone bool) error {
+	if len(done) == 0 {
+		return nil
+	}
+
+	ops, err := h.Load()
+	if err != nil {
+		return err
+	}
+
+	ids := make(map[int]bool, len(done))
+	for _, op := range done {
+		ids[op.ID] = true
+	}
+	for i := range ops {
+		if ids[ops[i].ID] {
+			ops[i].Undone = undone
+		}
+	}
+
+	return h.Save(ops)
+}
+
+func FileStateOf(r Reader, file string) (*FileState, error) {
+	has, err := r.Has(file)
+	if err != nil || !has {
+		return nil, err
+	}
+
+	tags, err := r.TagsFor(file)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := r.Attrs(file)
+	if err != nil {
+		return nil, err
+	}
+	fp, ok, err := r.Fingerprint(file)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &FileState{
+		Tags:  tags,
+		Attrs: attrs,
+	}
+	if len(state.Attrs) == 0 {
+		state.Attrs = nil
+	}
+	if ok {
+		state.Fingerprint = &fp
+	}
+	return state, nil
+}
+
+// RestoreFile replaces what the tag map holds for file with state.
+func RestoreFile(tx Tx, file string, state *FileState) error {
+	err := tx.Clear(file)
+	if err != nil || state == nil {
+		return err
+	}
+
+	err = tx.Add(file, state.Tags...)
+	if err != nil {
+		return err
+	}
+	for key, value := range state.Attrs {
+		err = tx.SetAttr(file, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	if state.Fingerprint != nil {
+		return tx.SetFingerprint(file, *state.Fingerprint)
+	}
+	return nil
+}
+
+// RecordingTx notes the state of each file before its first change, so
+// that the changes made through it can be recorded in a History.
+type RecordingTx struct {
+	Tx
+
+	before map[string]*FileState
+}
+
+func NewRecordingTx(tx Tx) *RecordingTx {
+	return &RecordingTx{
+		Tx:     tx,
+		before: make(map[string]*FileState),
+	}
+}
+
+func (rt *RecordingTx) touch(files ...string) error {
+	for _, file := range files {
+		if _, ok := rt.before[file]; ok {
+			continue
+		}
+		state, err := FileStateOf(rt.Tx, file)
+		if err != nil {
+			return err
+		}
+		rt.before[file] = state
+	}
+	return nil
+}
+
+// Changes lists the files whose state differs from before the first
+// change, sorted by file.
+func (rt *RecordingTx) Changes() ([]FileChange, error) {
+	files := make([]string, 0, len(rt.before))
+	for file := range rt.before {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	changes := []FileChange{}
+	for _, file := range files {
+		after, err := FileStateOf(rt.Tx, file)
+		if err != nil {
+			return nil, err
+		}
+		before := rt.before[file]
+		if reflect.DeepEqual(before, after) {
+			continue
+		}
+		changes = append(changes, FileChange{
+			File:   file,
+			Before: before,
+			After:  after,
+		})
+	}
+	return changes, nil
+}
+
+func (rt *RecordingTx) Add(file string, tags ...string) error {
+	if err := rt.touch(file); err != nil {
+		return err
+	}
+	return rt.Tx.Add(file, tags...)
+}
+
+func (rt *RecordingTx) Remove(file string, tags ...string) error {
+	if err := rt.touch(file); err != nil {
+		return err
+	}
+	return rt.Tx.Remove(file, tags...)
+}
+
+func (rt *RecordingTx) Clear(file string) error {
+	if err := rt.touch(file); err != nil {
+		return err
+	}
+	return rt.Tx.Clear(file)
+}
+
+func (rt *RecordingTx) Move(from, to string) (bool, error) {
+	if err := rt.touch(from, to); err != nil {
+		return false, err
+	}
+	return rt.Tx.Move(from, to)
+}
+
+func (rt *RecordingTx) SetAttr(file, key string, value interface{}) error {
+	if err := rt.touch(file); err != nil {
+		return err
+	}
+	return rt.Tx.SetAttr(file, key, value)
+}
+
+func (rt *RecordingTx) UnsetAttr(file, key string) error {
+	if err := rt.touch(file); err != nil {
+		return err
+	}
+	return rt.Tx.UnsetAttr(file, key)
+}
+
+// Rebuild notes the state of every file first, since repairing the index
+// may change any of them.
+func (rt *RecordingTx) Rebuild() ([]error, error) {
+	files, err := rt.Tx.Files()
+	if err != nil {
+		return nil, err
+	}
+	if err := rt.touch(files...); err != nil {
+		return nil, err
+	}
+	return rt.Tx.Rebuild()
+}
+
+func (rt *RecordingTx) SetFingerprint(file string, fp Fingerprint) error {
+	if err := rt.touch(file); err != nil {
+		return err
+	}
+	return rt.Tx.SetFingerprint(file, fp)
+}
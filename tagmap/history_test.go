@@ -0,0 +1,209 @@
+package tagmap_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var _ = Describe("History", func() {
+
+	var dir string
+	var h *tagmap.History
+	var tm *tagmap.TM
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+
+		h = tagmap.NewHistory(filepath.Join(dir, ".ftag.history"))
+		h.User = "alice"
+		tm = tagmap.New()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	record := func(command string, fn func(tx tagmap.Tx)) {
+		rtx := tagmap.NewRecordingTx(tagmap.NewMapTx(tm))
+		fn(rtx)
+		changes, err := rtx.Changes()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.Record(command, changes)).To(Succeed())
+	}
+
+	It("should record the state of changed files before and after", func() {
+		record("add", func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.SetAttr("foo", "k", "v")).To(Succeed())
+			Expect(tx.Add("bar", "tag1")).To(Succeed())
+			Expect(tx.Remove("bar", "tag1")).To(Succeed())
+		})
+
+		ops, err := h.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(HaveLen(1))
+		Expect(ops[0].ID).To(Equal(1))
+		Expect(ops[0].User).To(Equal("alice"))
+		Expect(ops[0].Command).To(Equal("add"))
+		Expect(ops[0].Changes).To(Equal([]tagmap.FileChange{
+			{
+				File:  "foo",
+				After: &tagmap.FileState{Tags: []string{"tag1"}, Attrs: tagmap.Attrs{"k": "v"}},
+			},
+		}))
+	})
+
+	It("should not record operations that change nothing", func() {
+		record("rm", func(tx tagmap.Tx) {
+			Expect(tx.Remove("foo", "tag1")).To(Succeed())
+		})
+
+		ops, err := h.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(BeEmpty())
+	})
+
+	It("should undo and redo operations", func() {
+		record("add", func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1", "tag2")).To(Succeed())
+		})
+		record("clear", func(tx tagmap.Tx) {
+			Expect(tx.Clear("foo")).To(Succeed())
+		})
+		Expect(tm.ListFiles()).To(BeEmpty())
+
+		ops, err := h.Undo(tagmap.NewMapTx(tm), 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(HaveLen(1))
+		Expect(ops[0].Command).To(Equal("clear"))
+		Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1", "tag2"}))
+		Expect(h.SetUndone(ops, true)).To(Succeed())
+
+		ops, err = h.Undo(tagmap.NewMapTx(tm), 5)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(HaveLen(1))
+		Expect(tm.ListFiles()).To(BeEmpty())
+		Expect(h.SetUndone(ops, true)).To(Succeed())
+
+		ops, err = h.Redo(tagmap.NewMapTx(tm), 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops[0].Command).To(Equal("add"))
+		Expect(h.SetUndone(ops, false)).To(Succeed())
+		Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1", "tag2"}))
+	})
+
+	It("should undo a move", func() {
+		record("add", func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h"})).To(Succeed())
+		})
+		record("mv", func(tx tagmap.Tx) {
+			Expect(tx.Move("foo", "bar")).To(BeTrue())
+		})
+
+		ops, err := h.Undo(tagmap.NewMapTx(tm), 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.SetUndone(ops, true)).To(Succeed())
+		Expect(tm.ListFiles()).To(Equal([]string{"foo"}))
+		fp, ok := tm.Fingerprint("foo")
+		Expect(ok).To(BeTrue())
+		Expect(fp.Hash).To(Equal("h"))
+	})
+
+	It("should discard undone operations when a new one is recorded", func() {
+		record("add", func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+		ops, err := h.Undo(tagmap.NewMapTx(tm), 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(h.SetUndone(ops, true)).To(Succeed())
+
+		record("add", func(tx tagmap.Tx) {
+			Expect(tx.Add("bar", "tag1")).To(Succeed())
+		})
+
+		ops, err = h.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(HaveLen(1))
+		Expect(ops[0].ID).To(Equal(2))
+
+		ops, err = h.Redo(tagmap.NewMapTx(tm), 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(BeEmpty())
+	})
+
+	It("should not mark operations undone until told to", func() {
+		record("add", func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+
+		_, err := h.Undo(tagmap.NewMapTx(tagmap.New()), 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		ops, err := h.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops[0].Undone).To(BeFalse())
+	})
+
+	It("should record the files changed by rebuilding the index", func() {
+		tm.FileToTag["foo"] = []string{"tag1", "tag1"}
+		tm.TagToFile["tag1"] = []string{"foo"}
+
+		record("check --fix", func(tx tagmap.Tx) {
+			Expect(tx.Rebuild()).ToNot(BeEmpty())
+		})
+
+		ops, err := h.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(HaveLen(1))
+		Expect(ops[0].Changes).To(HaveLen(1))
+		Expect(ops[0].Changes[0].After.Tags).To(Equal([]string{"tag1"}))
+	})
+
+	It("should not lose operations recorded concurrently under its lock", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer GinkgoRecover()
+
+				other := tagmap.NewHistory(filepath.Join(dir, ".ftag.history"))
+				Expect(other.WithLock(func() error {
+					return other.Record("add", []tagmap.FileChange{
+						{File: fmt.Sprintf("file%d", i), After: &tagmap.FileState{Tags: []string{"tag1"}}},
+					})
+				})).To(Succeed())
+			}(i)
+		}
+		wg.Wait()
+
+		ops, err := h.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(HaveLen(10))
+	})
+
+	It("should keep only the most recent operations", func() {
+		h.Limit = 2
+		for _, file := range []string{"a", "b", "c"} {
+			record("add "+file, func(tx tagmap.Tx) {
+				Expect(tx.Add(file, "tag1")).To(Succeed())
+			})
+		}
+
+		ops, err := h.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ops).To(HaveLen(2))
+		Expect(ops[0].Command).To(Equal("add b"))
+		Expect(ops[1].ID).To(Equal(3))
+	})
+
+})
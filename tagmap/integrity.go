@@ -0,0 +1,111 @@
+package tagmap
+
+import (
+	"fmt"
+	"sort"
+)
+
+type IntegrityError struct {
+	msg string
+}
+
+func (e *IntegrityError) Error() string {
+	return e.msg
+}
+
+func integrityErrorf(format string, args ...interface{}) *IntegrityError {
+	return &IntegrityError{
+		msg: fmt.Sprintf(format, args...),
+	}
+}
+
+// Verify checks that FileToTag and TagToFile are exact inverses of each
+// other, without duplicate or empty lists.
+func (tm *TM) Verify() []error {
+	result := make([]error, 0)
+
+	result = append(result, verifyLists(tm.FileToTag, "file %q lists tag %q more than once", "file %q has an empty tag list")...)
+	result = append(result, verifyLists(tm.TagToFile, "tag %q lists file %q more than once", "tag %q has an empty file list")...)
+
+	for _, file := range sortedKeys(tm.FileToTag) {
+		for _, tag := range tm.FileToTag[file] {
+			if !tm.TagToFile.HasValue(tag, file) {
+				result = append(result, integrityErrorf("file %q has tag %q, but the tag does not list the file", file, tag))
+			}
+		}
+	}
+
+	for _, tag := range sortedKeys(tm.TagToFile) {
+		for _, file := range tm.TagToFile[tag] {
+			if !tm.FileToTag.HasValue(file, tag) {
+				result = append(result, integrityErrorf("tag %q lists file %q, but the file does not have the tag", tag, file))
+			}
+		}
+	}
+
+	files := make([]string, 0, len(tm.FileToFingerprint))
+	for file := range tm.FileToFingerprint {
+		if !tm.Has(file) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		result = append(result, integrityErrorf("file %q has a fingerprint but no tags or attributes", file))
+	}
+
+	return result
+}
+
+func verifyLists(slm StringListMap, duplicateFormat, emptyFormat string) []error {
+	result := make([]error, 0)
+
+	for _, key := range sortedKeys(slm) {
+		list := slm[key]
+		if len(list) == 0 {
+			result = append(result, integrityErrorf(emptyFormat, key))
+			continue
+		}
+
+		seen := make(map[string]bool)
+		reported := make(map[string]bool)
+		for _, value := range list {
+			if seen[value] && !reported[value] {
+				result = append(result, integrityErrorf(duplicateFormat, key, value))
+				reported[value] = true
+			}
+			seen[value] = true
+		}
+	}
+
+	return result
+}
+
+// Rebuild treats FileToTag as authoritative, dropping duplicate and empty
+// entries from it, and regenerates TagToFile as its inverse. It returns
+// the problems Verify found beforehand.
+func (tm *TM) Rebuild() []error {
+	problems := tm.Verify()
+
+	fileToTag := tm.FileToTag
+	tm.FileToTag = make(StringListMap)
+	tm.TagToFile = make(StringListMap)
+
+	for file, tags := range fileToTag {
+		for _, tag := range tags {
+			tm.Add(file, tag)
+		}
+	}
+
+	for file := range tm.FileToFingerprint {
+		tm.prune(file)
+	}
+
+	return problems
+}
+
+func sortedKeys(slm StringListMap) []string {
+	keys := slm.Keys()
+	sort.Strings(keys)
+	return keys
+}
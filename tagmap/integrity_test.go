@@ -0,0 +1,81 @@
+package tagmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("Integrity", func() {
+
+	messages := func(errs []error) []string {
+		result := []string{}
+		for _, err := range errs {
+			result = append(result, err.Error())
+		}
+		return result
+	}
+
+	Describe("Verify", func() {
+
+		It("should find no problems in a consistent map", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			tm.Add("bar", "tag1")
+			tm.Add("bar", "tag2")
+			Expect(tm.Verify()).To(BeEmpty())
+		})
+
+		It("should report duplicates, empty lists and missing inverses", func() {
+			tm := tagmap.New()
+			tm.FileToTag["foo"] = []string{"tag1", "tag1", "tag2"}
+			tm.FileToTag["bar"] = []string{}
+			tm.TagToFile["tag1"] = []string{"foo", "baz"}
+			tm.TagToFile["tag3"] = []string{}
+
+			Expect(messages(tm.Verify())).To(Equal([]string{
+				`file "bar" has an empty tag list`,
+				`file "foo" lists tag "tag1" more than once`,
+				`tag "tag3" has an empty file list`,
+				`file "foo" has tag "tag2", but the tag does not list the file`,
+				`tag "tag1" lists file "baz", but the file does not have the tag`,
+			}))
+		})
+
+		It("should report fingerprints of untagged files", func() {
+			tm := tagmap.New()
+			tm.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h"})
+			Expect(messages(tm.Verify())).To(Equal([]string{
+				`file "foo" has a fingerprint but no tags or attributes`,
+			}))
+		})
+
+	})
+
+	Describe("Rebuild", func() {
+
+		It("should rebuild TagToFile from FileToTag", func() {
+			tm := tagmap.New()
+			tm.FileToTag["foo"] = []string{"tag1", "tag1", "tag2"}
+			tm.FileToTag["bar"] = []string{}
+			tm.TagToFile["tag1"] = []string{"foo", "baz"}
+			tm.TagToFile["tag3"] = []string{}
+			tm.SetFingerprint("baz", tagmap.Fingerprint{Hash: "h"})
+
+			fixed := tm.Rebuild()
+			Expect(fixed).To(HaveLen(6))
+			Expect(tm.Verify()).To(BeEmpty())
+
+			Expect(tm.FileToTag).To(Equal(tagmap.StringListMap{
+				"foo": []string{"tag1", "tag2"},
+			}))
+			Expect(tm.TagToFile).To(Equal(tagmap.StringListMap{
+				"tag1": []string{"foo"},
+				"tag2": []string{"foo"},
+			}))
+			Expect(tm.FileToFingerprint).To(BeEmpty())
+		})
+
+	})
+
+})
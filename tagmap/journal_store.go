@@ -0,0 +1,564 @@
+package tagmap
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"time"
+)
+
+const DefaultCompactAfter = 1000
+
+const (
+	OpAdd            = "add"
+	OpRemove         = "remove"
+	OpClear          = "clear"
+	OpMove           = "move"
+	OpSetAttr        = "set-attr"
+	OpUnsetAttr      = "unset-attr"
+	OpSetFingerprint = "set-fingerprint"
+)
+
+// JournalEntry records a single change to the tag map.
+type JournalEntry struct {
+	Time        time.Time       `json:"time"`
+	User        string          `json:"user,omitempty"`
+	Op          string          `json:"op"`
+	File        string          `json:"file"`
+	Tags        []string        `json:"tags,omitempty"`
+	To          string          `json:"to,omitempty"`
+	Key         string          `json:"key,omitempty"`
+	Value       json.RawMessage `json:"value,omitempty"`
+	Fingerprint *Fingerprint    `json:"fingerprint,omitempty"`
+}
+
+// ErrJournalMismatch is returned when the snapshot was changed by other
+// means than the journal store, so that the journal can't be replayed
+// over it.
+var ErrJournalMismatch = errors.New("journal does not match the tag map snapshot")
+
+// journalHeader starts a journal and identifies the snapshot its entries
+// apply to.
+type journalHeader struct {
+	Snapshot string `json:"snapshot"`
+}
+
+// journalFooter ends a journal being compacted and identifies the new
+// snapshot that includes its entries. A journal left behind by a
+// compaction interrupted after the snapshot was written is stale and
+// ignored.
+type journalFooter struct {
+	Compacted string `json:"compacted"`
+}
+
+// JournalStore keeps a JSON snapshot of the tag map, in the same format as
+// JSONFileStore, and appends each change to a journal beside it. Loading
+// replays the journal over the snapshot, and compaction folds the journal
+// into a new snapshot.
+type JournalStore struct {
+	snapshot *JSONFileStore
+
+	User         string
+	CompactAfter int
+}
+
+func NewJournalStore(path string) *JournalStore {
+	return &JournalStore{
+		snapshot:     NewJSONFileStore(path),
+		User:         currentUser(),
+		CompactAfter: DefaultCompactAfter,
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// Snapshot returns the store holding the snapshot, for the lock timeout
+// and backup settings.
+func (js *JournalStore) Snapshot() *JSONFileStore {
+	return js.snapshot
+}
+
+func (js *JournalStore) journalPath() string {
+	return js.snapshot.path + ".journal"
+}
+
+func (js *JournalStore) Load() (*TM, error) {
+	lock, err := acquireLock(js.snapshot.lockPath(), false, js.snapshot.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	tm, _, err := js.load()
+	return tm, err
+}
+
+// Put writes tm as the snapshot of a new store, and otherwise records its
+// differences from the stored map in the journal.
+func (js *JournalStore) Put(tm *TM) error {
+	if _, err := os.Stat(js.snapshot.path); os.IsNotExist(err) {
+		lock, err := acquireLock(js.snapshot.lockPath(), true, js.snapshot.LockTimeout)
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		return js.compact(tm)
+	}
+
+	return js.update(func(tx Tx, current *TM) error {
+		return putChanges(tx, current.Clone(), tm)
+	})
+}
+
+func (js *JournalStore) View(fn func(tx Tx) error) error {
+	tm, err := js.Load()
+	if err != nil {
+		return err
+	}
+
+	return fn(NewMapTx(tm))
+}
+
+func (js *JournalStore) Update(fn func(tx Tx) error) error {
+	return js.update(func(tx Tx, _ *TM) error {
+		return fn(tx)
+	})
+}
+
+func (js *JournalStore) update(fn func(tx Tx, current *TM) error) error {
+	lock, err := acquireLock(js.snapshot.lockPath(), true, js.snapshot.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	tm, entries, err := js.load()
+	if err != nil {
+		return err
+	}
+
+	err = checkVersionWritable(tm)
+	if err != nil {
+		return err
+	}
+
+	jtx := &journalTx{
+		Tx:   NewMapTx(tm),
+		user: js.User,
+		now:  time.Now().UTC(),
+	}
+	err = fn(jtx, tm)
+	if err != nil {
+		return err
+	}
+
+	if jtx.rebuilt || (js.CompactAfter > 0 && len(entries)+len(jtx.entries) >= js.CompactAfter) {
+		return js.compact(tm)
+	}
+	if len(jtx.entries) == 0 {
+		return nil
+	}
+
+	return js.append(jtx.entries)
+}
+
+// Compact writes the tag map as a new snapshot and discards the journal.
+func (js *JournalStore) Compact() error {
+	lock, err := acquireLock(js.snapshot.lockPath(), true, js.snapshot.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	tm, _, err := js.load()
+	if err != nil {
+		return err
+	}
+
+	return js.compact(tm)
+}
+
+// Migrate upgrades the snapshot's format. The journal is replayed over the
+// upgraded map and compacted into it, since a rewritten snapshot would no
+// longer match the journal.
+func (js *JournalStore) Migrate(dryRun bool) ([]MigrationStep, error) {
+	lock, err := acquireLock(js.snapshot.lockPath(), true, js.snapshot.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	_, steps, err := js.snapshot.migrate()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun || len(steps) == 0 {
+		return steps, nil
+	}
+
+	tm, _, err := js.load()
+	if err != nil {
+		return nil, err
+	}
+
+	err = js.compact(tm)
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// Journal returns the changes recorded since the last compaction.
+func (js *JournalStore) Journal() ([]JournalEntry, error) {
+	lock, err := acquireLock(js.snapshot.lockPath(), false, js.snapshot.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	_, entries, err := js.load()
+	return entries, err
+}
+
+func (js *JournalStore) load() (*TM, []JournalEntry, error) {
+	tm, err := js.snapshot.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := js.readJournal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, e := range entries {
+		err = e.apply(tm)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return tm, entries, nil
+}
+
+func (js *JournalStore) snapshotHash() (string, error) {
+	data, err := ioutil.ReadFile(js.snapshot.path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return hashSnapshot(data), nil
+}
+
+func hashSnapshot(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (js *JournalStore) readJournal() ([]JournalEntry, error) {
+	f, err := os.Open(js.journalPath())
+	if os.IsNotExist(err) {
+		return []JournalEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	entries := []JournalEntry{}
+	var header journalHeader
+	var footer journalFooter
+	first := true
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		// A final line without a newline was cut short by a crash
+		// before the append completed.
+		if err == io.EOF {
+			break
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		if first {
+			first = false
+			if err := json.Unmarshal(line, &header); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", js.journalPath(), lineNum, err)
+			}
+			continue
+		}
+
+		var e JournalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", js.journalPath(), lineNum, err)
+		}
+		if e.Op == "" {
+			if err := json.Unmarshal(line, &footer); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", js.journalPath(), lineNum, err)
+			}
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	// A journal without a complete header was cut short by a crash
+	// before its first append completed, and holds no changes; the next
+	// append writes the header again.
+	if first {
+		return entries, nil
+	}
+
+	hash, err := js.snapshotHash()
+	if err != nil {
+		return nil, err
+	}
+	switch hash {
+	case header.Snapshot:
+		return entries, nil
+	case footer.Compacted:
+		return []JournalEntry{}, nil
+	}
+	return nil, fmt.Errorf("%w: %s was changed other than by the journal store, and the %d changes in %s can't be applied to it",
+		ErrJournalMismatch, js.snapshot.path, len(entries), js.journalPath())
+}
+
+func (js *JournalStore) append(entries []JournalEntry) error {
+	f, err := os.OpenFile(js.journalPath(), os.O_RDWR|os.O_CREATE, defaultFileMode)
+	if err != nil {
+		return err
+	}
+
+	err = js.writeEntries(f, entries)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// seekEnd positions f after its last complete line, dropping a partial
+// line left by an interrupted append so that it can't run into what is
+// written next. It returns the new length of f.
+func seekEnd(f *os.File) (int64, error) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+
+	end := int64(bytes.LastIndexByte(data, '\n') + 1)
+	if end < int64(len(data)) {
+		if err := f.Truncate(end); err != nil {
+			return 0, err
+		}
+	}
+	_, err = f.Seek(end, io.SeekStart)
+	return end, err
+}
+
+func (js *JournalStore) writeEntries(f *os.File, entries []JournalEntry) error {
+	end, err := seekEnd(f)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if end == 0 {
+		hash, err := js.snapshotHash()
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(journalHeader{Snapshot: hash}); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// compact marks the journal with the new snapshot, then replaces the
+// snapshot before removing the journal; if interrupted in between, the
+// marked journal is recognized as stale and ignored.
+func (js *JournalStore) compact(tm *TM) error {
+	data, err := encodeJSON(tm)
+	if err != nil {
+		return err
+	}
+
+	err = js.markCompacted(hashSnapshot(data))
+	if err != nil {
+		return err
+	}
+
+	err = js.snapshot.put(tm)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(js.journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (js *JournalStore) markCompacted(hash string) error {
+	f, err := os.OpenFile(js.journalPath(), os.O_RDWR, defaultFileMode)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = seekEnd(f)
+	var line []byte
+	if err == nil {
+		line, err = json.Marshal(journalFooter{Compacted: hash})
+	}
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (e *JournalEntry) apply(tm *TM) error {
+	switch e.Op {
+	case OpAdd:
+		for _, tag := range e.Tags {
+			tm.Add(e.File, tag)
+		}
+	case OpRemove:
+		for _, tag := range e.Tags {
+			tm.Remove(e.File, tag)
+		}
+	case OpClear:
+		tm.Clear(e.File)
+	case OpMove:
+		tm.Move(e.File, e.To)
+	case OpSetAttr:
+		var v interface{}
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return err
+		}
+		tm.SetAttr(e.File, e.Key, v)
+	case OpUnsetAttr:
+		tm.UnsetAttr(e.File, e.Key)
+	case OpSetFingerprint:
+		if e.Fingerprint != nil {
+			tm.SetFingerprint(e.File, *e.Fingerprint)
+		}
+	default:
+		return fmt.Errorf("unknown journal operation: %q", e.Op)
+	}
+	return nil
+}
+
+// journalTx applies changes to the loaded map and records them as entries
+// to append once the update succeeds.
+type journalTx struct {
+	Tx
+
+	user    string
+	now     time.Time
+	entries []JournalEntry
+	rebuilt bool
+}
+
+func (jt *journalTx) record(e JournalEntry) {
+	e.Time = jt.now
+	e.User = jt.user
+	jt.entries = append(jt.entries, e)
+}
+
+func (jt *journalTx) Add(file string, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	jt.record(JournalEntry{Op: OpAdd, File: file, Tags: tags})
+	return jt.Tx.Add(file, tags...)
+}
+
+func (jt *journalTx) Remove(file string, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	jt.record(JournalEntry{Op: OpRemove, File: file, Tags: tags})
+	return jt.Tx.Remove(file, tags...)
+}
+
+func (jt *journalTx) Clear(file string) error {
+	jt.record(JournalEntry{Op: OpClear, File: file})
+	return jt.Tx.Clear(file)
+}
+
+func (jt *journalTx) Move(from, to string) (bool, error) {
+	moved, err := jt.Tx.Move(from, to)
+	if moved {
+		jt.record(JournalEntry{Op: OpMove, File: from, To: to})
+	}
+	return moved, err
+}
+
+func (jt *journalTx) SetAttr(file, key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	jt.record(JournalEntry{Op: OpSetAttr, File: file, Key: key, Value: b})
+	return jt.Tx.SetAttr(file, key, value)
+}
+
+func (jt *journalTx) UnsetAttr(file, key string) error {
+	jt.record(JournalEntry{Op: OpUnsetAttr, File: file, Key: key})
+	return jt.Tx.UnsetAttr(file, key)
+}
+
+func (jt *journalTx) SetFingerprint(file string, fp Fingerprint) error {
+	jt.record(JournalEntry{Op: OpSetFingerprint, File: file, Fingerprint: &fp})
+	return jt.Tx.SetFingerprint(file, fp)
+}
+
+// Rebuild repairs the loaded map directly, which can't be replayed from
+// entries, so the update is saved by compacting.
+func (jt *journalTx) Rebuild() ([]error, error) {
+	problems, err := jt.Tx.Rebuild()
+	if err == nil && len(problems) > 0 {
+		jt.rebuilt = true
+	}
+	return problems, err
+}
@@ -0,0 +1,290 @@
+package tagmap_test
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var _ = Describe("JournalStore", func() {
+
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, ".ftag")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	newStore := func() *tagmap.JournalStore {
+		s := tagmap.NewJournalStore(path)
+		s.User = "alice"
+		return s
+	}
+
+	update := func(fn func(tx tagmap.Tx)) {
+		err := newStore().Update(func(tx tagmap.Tx) error {
+			fn(tx)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	load := func() *tagmap.TM {
+		tm, err := newStore().Load()
+		Expect(err).ToNot(HaveOccurred())
+		return tm
+	}
+
+	journalLines := func() []string {
+		b, err := ioutil.ReadFile(path + ".journal")
+		Expect(err).ToNot(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+
+	It("should append changes to the journal without writing a snapshot", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1", "tag2")).To(Succeed())
+			Expect(tx.SetAttr("foo", "done", false)).To(Succeed())
+		})
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Remove("foo", "tag1")).To(Succeed())
+			Expect(tx.Move("foo", "bar")).To(BeTrue())
+		})
+
+		_, err := os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(journalLines()).To(HaveLen(5))
+
+		tm := load()
+		Expect(tm.FileToTag).To(Equal(tagmap.StringListMap{
+			"bar": []string{"tag2"},
+		}))
+		Expect(tm.FileToAttr["bar"]).To(Equal(tagmap.Attrs{"done": false}))
+	})
+
+	It("should record who made each change", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.Clear("foo")).To(Succeed())
+		})
+
+		entries, err := newStore().Journal()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Op).To(Equal(tagmap.OpAdd))
+		Expect(entries[0].User).To(Equal("alice"))
+		Expect(entries[0].Tags).To(Equal([]string{"tag1"}))
+		Expect(entries[1].Op).To(Equal(tagmap.OpClear))
+		Expect(entries[1].Time.IsZero()).To(BeFalse())
+	})
+
+	It("should fold the journal into the snapshot when compacted", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+		Expect(newStore().Compact()).To(Succeed())
+
+		_, err := os.Stat(path + ".journal")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		tm, err := tagmap.NewJSONFileStore(path).Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1"}))
+	})
+
+	It("should compact after the configured number of entries", func() {
+		s := newStore()
+		s.CompactAfter = 3
+		for i := 0; i < 3; i++ {
+			err := s.Update(func(tx tagmap.Tx) error {
+				return tx.Add(fmt.Sprintf("file%d", i), "tag")
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		_, err := os.Stat(path + ".journal")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(load().TagToFile["tag"]).To(HaveLen(3))
+	})
+
+	It("should ignore a journal left behind by an interrupted compaction", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.Move("foo", "bar")).To(BeTrue())
+			Expect(tx.Add("foo", "tag2")).To(Succeed())
+		})
+		journal, err := ioutil.ReadFile(path + ".journal")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(newStore().Compact()).To(Succeed())
+		snapshot, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		sum := sha256.Sum256(snapshot)
+		journal = append(journal, fmt.Sprintf(`{"compacted":"sha256:%x"}`+"\n", sum)...)
+		Expect(ioutil.WriteFile(path+".journal", journal, 0644)).To(Succeed())
+
+		Expect(load().FileToTag).To(Equal(tagmap.StringListMap{
+			"bar": []string{"tag1"},
+			"foo": []string{"tag2"},
+		}))
+	})
+
+	It("should fail when the snapshot was rewritten behind the journal", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+
+		jsonStore := tagmap.NewJSONFileStore(path)
+		Expect(jsonStore.Update(func(tx tagmap.Tx) error {
+			return tx.Add("bar", "tag2")
+		})).To(Succeed())
+
+		_, err := newStore().Load()
+		Expect(errors.Is(err, tagmap.ErrJournalMismatch)).To(BeTrue())
+
+		err = newStore().Update(func(tx tagmap.Tx) error {
+			return tx.Add("baz", "tag3")
+		})
+		Expect(errors.Is(err, tagmap.ErrJournalMismatch)).To(BeTrue())
+		Expect(journalLines()).To(HaveLen(2))
+	})
+
+	It("should migrate the snapshot and compact the journal into it", func() {
+		v1 := `{"version":"1","fileToTag":{"./foo":["tag1"]},"tagToFile":{"tag1":["./foo"]}}`
+		Expect(ioutil.WriteFile(path, []byte(v1), 0644)).To(Succeed())
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("bar", "tag2")).To(Succeed())
+		})
+
+		steps, err := newStore().Migrate(true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(steps[0].Changes).To(Equal([]string{"rename ./foo -> foo"}))
+		b, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal(v1))
+		Expect(journalLines()).To(HaveLen(2))
+
+		steps, err = newStore().Migrate(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(steps[len(steps)-1].To).To(Equal(tagmap.TM_VERSION))
+		_, err = os.Stat(path + ".journal")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		tm, err := tagmap.NewJSONFileStore(path).Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tm.Version).To(Equal(tagmap.TM_VERSION))
+		Expect(tm.ListFiles()).To(ConsistOf("foo", "bar"))
+
+		steps, err = newStore().Migrate(true)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(steps).To(BeEmpty())
+	})
+
+	It("should drop a partially written entry", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+		f, err := os.OpenFile(path+".journal", os.O_WRONLY|os.O_APPEND, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.WriteString(`{"op":"add","fi`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		Expect(load().ListFiles()).To(Equal([]string{"foo"}))
+
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("bar", "tag1")).To(Succeed())
+		})
+		Expect(load().TagToFile["tag1"]).To(ConsistOf("foo", "bar"))
+		Expect(journalLines()).To(HaveLen(3))
+	})
+
+	It("should ignore a journal cut short before its header was written", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+		Expect(newStore().Compact()).To(Succeed())
+
+		for i, partial := range []string{"", `{"snapshot":"sha2`} {
+			Expect(ioutil.WriteFile(path+".journal", []byte(partial), 0644)).To(Succeed())
+			files := load().ListFiles()
+
+			file := fmt.Sprintf("file%d", i)
+			update(func(tx tagmap.Tx) {
+				Expect(tx.Add(file, "tag1")).To(Succeed())
+			})
+			Expect(load().ListFiles()).To(ConsistOf(append(files, file)))
+			Expect(journalLines()).To(HaveLen(2))
+			Expect(newStore().Compact()).To(Succeed())
+		}
+	})
+
+	It("should not record anything when the update function fails", func() {
+		failure := errors.New("nope")
+		err := newStore().Update(func(tx tagmap.Tx) error {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			return failure
+		})
+		Expect(err).To(Equal(failure))
+
+		_, err = os.Stat(path + ".journal")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("should write a new store's snapshot with Put", func() {
+		tm := tagmap.New()
+		tm.Add("foo", "tag1")
+		Expect(newStore().Put(tm)).To(Succeed())
+
+		_, err := os.Stat(path + ".journal")
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		Expect(load().ListFiles()).To(Equal([]string{"foo"}))
+	})
+
+	It("should record the differences written with Put", func() {
+		Expect(newStore().Put(tagmap.New())).To(Succeed())
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+
+		tm := load()
+		tm.Add("bar", "tag2")
+		Expect(newStore().Put(tm)).To(Succeed())
+
+		Expect(journalLines()).To(HaveLen(3))
+		Expect(load().ListFiles()).To(ConsistOf("foo", "bar"))
+	})
+
+	It("should not lose concurrent updates", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				update(func(tx tagmap.Tx) {
+					Expect(tx.Add(fmt.Sprintf("file%d", i), "tag")).To(Succeed())
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(load().TagToFile["tag"]).To(HaveLen(20))
+	})
+
+})
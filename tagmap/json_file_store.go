@@ -1,13 +1,20 @@
 package tagmap
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 type JSONFileStore struct {
 	path string
+
+	LockTimeout time.Duration
+	Backups     int
 }
 
 func NewJSONFileStore(path string) *JSONFileStore {
@@ -16,43 +23,250 @@ func NewJSONFileStore(path string) *JSONFileStore {
 	}
 
 	return &JSONFileStore{
-		path: path,
+		path:        path,
+		LockTimeout: DefaultLockTimeout,
 	}
 }
 
+func (tmf *JSONFileStore) lockPath() string {
+	return tmf.path + ".lock"
+}
+
 func (tmf *JSONFileStore) Load() (*TM, error) {
+	lock, err := acquireLock(tmf.lockPath(), false, tmf.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
 
-	if _, err := os.Stat(tmf.path); err == nil {
-		f, err := os.Open(tmf.path)
-		defer f.Close()
+	return tmf.load()
+}
+
+func (tmf *JSONFileStore) Put(tm *TM) error {
+	lock, err := acquireLock(tmf.lockPath(), true, tmf.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return tmf.put(tm)
+}
+
+func (tmf *JSONFileStore) View(fn func(tx Tx) error) error {
+	tm, err := tmf.Load()
+	if err != nil {
+		return err
+	}
+
+	return fn(NewMapTx(tm))
+}
+
+func (tmf *JSONFileStore) Update(fn func(tx Tx) error) error {
+	lock, err := acquireLock(tmf.lockPath(), true, tmf.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	tm, err := tmf.load()
+	if err != nil {
+		return err
+	}
+
+	err = fn(NewMapTx(tm))
+	if err != nil {
+		return err
+	}
+
+	return tmf.put(tm)
+}
+
+func (tmf *JSONFileStore) Migrate(dryRun bool) ([]MigrationStep, error) {
+	lock, err := acquireLock(tmf.lockPath(), true, tmf.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
 
+	tm, steps, err := tmf.migrate()
+	if err != nil {
+		return nil, err
+	}
+
+	if !dryRun && len(steps) > 0 {
+		err = tmf.put(tm)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	return steps, nil
+}
+
+// migrate decodes the tag map file and upgrades it in memory, returning
+// the steps taken.
+func (tmf *JSONFileStore) migrate() (*TM, []MigrationStep, error) {
+	tm, err := tmf.decode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = checkVersionWritable(tm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	steps, err := migrate(tm, filepath.Dir(tmf.path))
+	if err != nil {
+		return nil, nil, err
+	}
+	return tm, steps, nil
+}
+
+// Format rewrites the tag map file in canonical form, returning whether
+// it was not already. With check set, the file is left as it is.
+func (tmf *JSONFileStore) Format(check bool) (bool, error) {
+	lock, err := acquireLock(tmf.lockPath(), !check, tmf.LockTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Unlock()
+
+	data, err := ioutil.ReadFile(tmf.path)
+	if err != nil {
+		return false, err
+	}
+
+	tm, err := tmf.load()
+	if err != nil {
+		return false, err
+	}
+
+	canonical, err := encodeJSON(tm)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, canonical) {
+		return false, nil
+	}
+	if check {
+		return true, nil
+	}
+
+	err = checkVersionWritable(tm)
+	if err != nil {
+		return false, err
+	}
+	return true, writeFileAtomic(tmf.path, canonical)
+}
+
+func (tmf *JSONFileStore) load() (*TM, error) {
+	tm, err := tmf.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = migrate(tm, filepath.Dir(tmf.path))
+	if err != nil {
+		return nil, err
+	}
+
+	return tm, nil
+}
+
+func (tmf *JSONFileStore) decode() (*TM, error) {
+
+	if _, err := os.Stat(tmf.path); err == nil {
+		f, err := os.Open(tmf.path)
+		defer f.Close()
 
-		var tm TM
-		dec := json.NewDecoder(f)
-		err = dec.Decode(&tm)
 		if err != nil {
 			return nil, err
 		}
 
-		return &tm, nil
+		return decodeJSON(f)
 	}
 
 	return New(), nil
 }
 
-func (tmf *JSONFileStore) Put(tm *TM) error {
+func decodeJSON(r io.Reader) (*TM, error) {
+	var tm TM
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&tm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tm, nil
+}
+
+// encodeJSON writes the canonical form of tm: keys and lists sorted,
+// indented with one entry per line so that changes diff and merge line by
+// line, and a trailing newline.
+func encodeJSON(tm *TM) ([]byte, error) {
+	b, err := json.MarshalIndent(tm.Normalize(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// ReadJSONFile reads a tag map file without locking it, upgrading it to
+// the current version with file names relative to root.
+func ReadJSONFile(path, root string) (*TM, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tm, err := decodeJSON(f)
+	if err != nil {
+		return nil, err
+	}
 
-	tm = tm.Normalize()
+	_, err = migrate(tm, root)
+	if err != nil {
+		return nil, err
+	}
+
+	return tm, nil
+}
+
+// WriteJSONFile replaces a tag map file without locking it.
+func WriteJSONFile(path string, tm *TM) error {
+	err := checkVersionWritable(tm)
+	if err != nil {
+		return err
+	}
+
+	jsonBytes, err := encodeJSON(tm)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, jsonBytes)
+}
+
+func (tmf *JSONFileStore) put(tm *TM) error {
+
+	err := checkVersionWritable(tm)
+	if err != nil {
+		return err
+	}
+
+	jsonBytes, err := encodeJSON(tm)
+	if err != nil {
+		return err
+	}
 
-	jsonBytes, err := json.Marshal(tm)
+	err = rotateBackups(tmf.path, tmf.Backups)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(tmf.path, jsonBytes, 0755)
+	err = writeFileAtomic(tmf.path, jsonBytes)
 	if err != nil {
 		return err
 	}
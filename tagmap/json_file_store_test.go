@@ -0,0 +1,362 @@
+package tagmap_test
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var _ = Describe("JSONFileStore", func() {
+
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, ".ftag")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("Load", func() {
+
+		It("should return an empty map when the file does not exist", func() {
+			tm, err := tagmap.NewJSONFileStore(path).Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag).To(BeEmpty())
+			Expect(tm.Version).To(Equal(tagmap.TM_VERSION))
+		})
+
+		It("should load a stored map", func() {
+			s := tagmap.NewJSONFileStore(path)
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			Expect(s.Put(tm)).To(Succeed())
+
+			tm, err := s.Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1"}))
+			Expect(tm.TagToFile["tag1"]).To(Equal([]string{"foo"}))
+		})
+
+		It("should migrate version 1 file names relative to the map directory", func() {
+			abs := filepath.ToSlash(filepath.Join(dir, "sub", "abs.txt"))
+			v1 := `{"version":"1",` +
+				`"fileToTag":{"./foo":["tag1"],"` + abs + `":["tag1","tag2"],"foo":["tag3"]},` +
+				`"tagToFile":{"tag1":["./foo","` + abs + `"],"tag2":["` + abs + `"],"tag3":["foo"]}}`
+			Expect(ioutil.WriteFile(path, []byte(v1), 0644)).To(Succeed())
+
+			tm, err := tagmap.NewJSONFileStore(path).Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.Version).To(Equal(tagmap.TM_VERSION))
+
+			Expect(tm.FileToTag).To(HaveLen(2))
+			Expect(tm.FileToTag["foo"]).To(ConsistOf("tag1", "tag3"))
+			Expect(tm.FileToTag["sub/abs.txt"]).To(ConsistOf("tag1", "tag2"))
+
+			Expect(tm.TagToFile["tag1"]).To(ConsistOf("foo", "sub/abs.txt"))
+			Expect(tm.TagToFile["tag2"]).To(Equal([]string{"sub/abs.txt"}))
+			Expect(tm.TagToFile["tag3"]).To(Equal([]string{"foo"}))
+		})
+
+		It("should load a map in a directory it can't write to", func() {
+			if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+				Skip("directory permissions are not enforced")
+			}
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			Expect(tagmap.NewJSONFileStore(path).Put(tm)).To(Succeed())
+			Expect(os.Remove(path + ".lock")).To(Succeed())
+			Expect(os.Chmod(dir, 0555)).To(Succeed())
+			defer os.Chmod(dir, 0755)
+
+			tm, err := tagmap.NewJSONFileStore(path).Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1"}))
+
+			tm, err = tagmap.NewJSONFileStore(filepath.Join(dir, ".nope")).Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag).To(BeEmpty())
+		})
+
+	})
+
+	Describe("Versions", func() {
+
+		v1 := `{"version":"1","fileToTag":{"./foo":["tag1"]},"tagToFile":{"tag1":["./foo"]}}`
+
+		It("should report migration steps without writing on a dry run", func() {
+			Expect(ioutil.WriteFile(path, []byte(v1), 0644)).To(Succeed())
+
+			steps, err := tagmap.NewJSONFileStore(path).Migrate(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(steps).ToNot(BeEmpty())
+			Expect(steps[0].From).To(Equal("1"))
+			Expect(steps[0].To).To(Equal("2"))
+			Expect(steps[0].Changes).To(Equal([]string{"rename ./foo -> foo"}))
+			Expect(steps[len(steps)-1].To).To(Equal(tagmap.TM_VERSION))
+
+			b, err := ioutil.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal(v1))
+		})
+
+		It("should write the migrated map", func() {
+			Expect(ioutil.WriteFile(path, []byte(v1), 0644)).To(Succeed())
+
+			s := tagmap.NewJSONFileStore(path)
+			_, err := s.Migrate(false)
+			Expect(err).ToNot(HaveOccurred())
+
+			steps, err := s.Migrate(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(steps).To(BeEmpty())
+		})
+
+		It("should read but refuse to write a map from a newer version", func() {
+			newer := `{"version":"999","fileToTag":{"foo":["tag1"]},"tagToFile":{"tag1":["foo"]}}`
+			Expect(ioutil.WriteFile(path, []byte(newer), 0644)).To(Succeed())
+
+			s := tagmap.NewJSONFileStore(path)
+			tm, err := s.Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1"}))
+
+			err = s.Update(func(tx tagmap.Tx) error {
+				return nil
+			})
+			Expect(errors.Is(err, tagmap.ErrNewerVersion)).To(BeTrue())
+
+			_, err = s.Migrate(false)
+			Expect(errors.Is(err, tagmap.ErrNewerVersion)).To(BeTrue())
+
+			b, err := ioutil.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal(newer))
+		})
+
+		It("should fail to load an unrecognized version", func() {
+			Expect(ioutil.WriteFile(path, []byte(`{"version":"bogus"}`), 0644)).To(Succeed())
+			_, err := tagmap.NewJSONFileStore(path).Load()
+			Expect(err).To(MatchError(`unrecognized tag map version: "bogus"`))
+		})
+
+	})
+
+	Describe("Put", func() {
+
+		put := func(s *tagmap.JSONFileStore, file string) {
+			tm := tagmap.New()
+			tm.Add(file, "tag")
+			Expect(s.Put(tm)).To(Succeed())
+		}
+
+		stored := func(p string) string {
+			tm, err := tagmap.NewJSONFileStore(p).Load()
+			Expect(err).ToNot(HaveOccurred())
+			return tm.ListFiles()[0]
+		}
+
+		It("should create the file with mode 0644", func() {
+			put(tagmap.NewJSONFileStore(path), "foo")
+			fi, err := os.Stat(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0644)))
+		})
+
+		It("should preserve the mode of an existing file", func() {
+			Expect(ioutil.WriteFile(path, []byte("{}"), 0600)).To(Succeed())
+			Expect(os.Chmod(path, 0600)).To(Succeed())
+
+			put(tagmap.NewJSONFileStore(path), "foo")
+			fi, err := os.Stat(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		It("should not leave temporary files behind", func() {
+			put(tagmap.NewJSONFileStore(path), "foo")
+			put(tagmap.NewJSONFileStore(path), "bar")
+
+			entries, err := ioutil.ReadDir(dir)
+			Expect(err).ToNot(HaveOccurred())
+			names := []string{}
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			Expect(names).To(ConsistOf(".ftag", ".ftag.lock"))
+		})
+
+		It("should keep no backups by default", func() {
+			put(tagmap.NewJSONFileStore(path), "foo")
+			put(tagmap.NewJSONFileStore(path), "bar")
+			_, err := os.Stat(path + ".bak")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should rotate the configured number of backups", func() {
+			s := tagmap.NewJSONFileStore(path)
+			s.Backups = 2
+			put(s, "one")
+			put(s, "two")
+			put(s, "three")
+			put(s, "four")
+
+			Expect(stored(path)).To(Equal("four"))
+			Expect(stored(path + ".bak")).To(Equal("three"))
+			Expect(stored(path + ".bak.2")).To(Equal("two"))
+			_, err := os.Stat(path + ".bak.3")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+	})
+
+	Describe("Format", func() {
+
+		It("should write one sorted entry per line", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			tm.Add("bar", "tag1")
+
+			s := tagmap.NewJSONFileStore(path)
+			Expect(s.Put(tm)).To(Succeed())
+
+			b, err := ioutil.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal(`{
+  "version": "4",
+  "fileToTag": {
+    "bar": [
+      "tag1"
+    ],
+    "foo": [
+      "tag1"
+    ]
+  },
+  "tagToFile": {
+    "tag1": [
+      "bar",
+      "foo"
+    ]
+  }
+}
+`))
+
+			loaded, err := s.Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.TagToFile["tag1"]).To(Equal([]string{"bar", "foo"}))
+		})
+
+		It("should rewrite a map that is not in canonical form", func() {
+			compact := `{"version":"4","fileToTag":{"foo":["tag2","tag1"]},"tagToFile":{"tag1":["foo"],"tag2":["foo"]}}`
+			Expect(ioutil.WriteFile(path, []byte(compact), 0644)).To(Succeed())
+			s := tagmap.NewJSONFileStore(path)
+
+			changed, err := s.Format(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+			b, err := ioutil.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal(compact))
+
+			changed, err = s.Format(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeTrue())
+
+			changed, err = s.Format(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changed).To(BeFalse())
+		})
+
+	})
+
+	Describe("Update", func() {
+
+		It("should store changes made by the function", func() {
+			s := tagmap.NewJSONFileStore(path)
+			err := s.Update(func(tx tagmap.Tx) error {
+				Expect(tx.Add("foo", "tag1")).To(Succeed())
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			tm, err := s.Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1"}))
+		})
+
+		It("should not store anything when the function fails", func() {
+			s := tagmap.NewJSONFileStore(path)
+			failure := errors.New("nope")
+			err := s.Update(func(tx tagmap.Tx) error {
+				Expect(tx.Add("foo", "tag1")).To(Succeed())
+				return failure
+			})
+			Expect(err).To(Equal(failure))
+
+			_, err = os.Stat(path)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("should not lose concurrent updates", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					err := tagmap.NewJSONFileStore(path).Update(func(tx tagmap.Tx) error {
+						return tx.Add(fmt.Sprintf("file%d", i), "tag")
+					})
+					Expect(err).ToNot(HaveOccurred())
+				}(i)
+			}
+			wg.Wait()
+
+			tm, err := tagmap.NewJSONFileStore(path).Load()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tm.FileToTag).To(HaveLen(20))
+			Expect(tm.TagToFile["tag"]).To(HaveLen(20))
+		})
+
+		It("should time out while another update holds the lock", func() {
+			locked := make(chan bool)
+			release := make(chan bool)
+			done := make(chan error)
+
+			go func() {
+				done <- tagmap.NewJSONFileStore(path).Update(func(tx tagmap.Tx) error {
+					locked <- true
+					<-release
+					return nil
+				})
+			}()
+			<-locked
+
+			s := tagmap.NewJSONFileStore(path)
+			s.LockTimeout = 100 * time.Millisecond
+			err := s.Update(func(tx tagmap.Tx) error {
+				return nil
+			})
+			Expect(errors.Is(err, tagmap.ErrLockTimeout)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(path + ".lock"))
+
+			close(release)
+			Expect(<-done).ToNot(HaveOccurred())
+		})
+
+	})
+
+})
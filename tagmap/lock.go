@@ -0,0 +1,65 @@
+package tagmap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLockTimeout = 10 * time.Second
+
+	lockPollInterval = 50 * time.Millisecond
+)
+
+var ErrLockTimeout = errors.New("timed out waiting for tag map lock")
+
+type fileLock interface {
+	Unlock() error
+}
+
+func acquireLock(path string, exclusive bool, timeout time.Duration) (fileLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := tryLock(path, exclusive)
+		if err != nil {
+			return nil, err
+		}
+		if l != nil {
+			return l, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("%w: %s is held by another process (waited %s)", ErrLockTimeout, path, timeout)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// noLock stands in for a shared lock on a map that doesn't exist in a
+// directory the caller can't write to.
+type noLock struct{}
+
+func (noLock) Unlock() error {
+	return nil
+}
+
+// openReadOnlyLock opens a file to hold a shared lock on for a reader that
+// can't create or write the lock file: the lock file if it exists, or else
+// the map file itself. Writers replace the map rather than write it in
+// place, so a reader holding it open still sees a whole map. Neither
+// existing is reported with a nil file and error.
+func openReadOnlyLock(path string) (*os.File, error) {
+	for _, p := range []string{path, strings.TrimSuffix(path, ".lock")} {
+		f, err := os.Open(p)
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,53 @@
+//go:build !windows
+// +build !windows
+
+package tagmap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+type flock struct {
+	f *os.File
+}
+
+// tryLock returns a nil lock without error when the lock is held elsewhere.
+func tryLock(path string, exclusive bool) (fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil && !exclusive && (os.IsPermission(err) || errors.Is(err, syscall.EROFS)) {
+		f, err = openReadOnlyLock(path)
+		if f == nil && err == nil {
+			return noLock{}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err = syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, nil
+		}
+		return nil, &os.PathError{Op: "flock", Path: path, Err: err}
+	}
+
+	return &flock{f: f}, nil
+}
+
+func (l *flock) Unlock() error {
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	cerr := l.f.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
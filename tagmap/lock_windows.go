@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+package tagmap
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFileEx is held with LockFileEx on the whole lock file, which Windows
+// releases when the holder exits, so that a killed process can't leave a
+// stale lock behind.
+type lockFileEx struct {
+	f *os.File
+}
+
+// lockRange covers the whole file, however large.
+const lockRange = ^uint32(0)
+
+// tryLock returns a nil lock without error when the lock is held elsewhere.
+func tryLock(path string, exclusive bool) (fileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil && !exclusive && os.IsPermission(err) {
+		f, err = openReadOnlyLock(path)
+		if f == nil && err == nil {
+			return noLock{}, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if exclusive {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	err = windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, lockRange, lockRange, &windows.Overlapped{})
+	if err != nil {
+		f.Close()
+		if err == windows.ERROR_LOCK_VIOLATION {
+			return nil, nil
+		}
+		return nil, &os.PathError{Op: "LockFileEx", Path: path, Err: err}
+	}
+
+	return &lockFileEx{f: f}, nil
+}
+
+func (l *lockFileEx) Unlock() error {
+	err := windows.UnlockFileEx(windows.Handle(l.f.Fd()), 0, lockRange, lockRange, &windows.Overlapped{})
+	cerr := l.f.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
@@ -0,0 +1,105 @@
+package tagmap
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MergeConflict is an attribute that both sides of a merge changed to
+// different values. The merged map keeps our value.
+type MergeConflict struct {
+	File   string
+	Key    string
+	Ours   interface{} // nil if we removed the attribute
+	Theirs interface{} // nil if they removed the attribute
+}
+
+func (c *MergeConflict) Error() string {
+	side := func(v interface{}) string {
+		if v == nil {
+			return "removed"
+		}
+		return FormatAttrValue(v)
+	}
+	return fmt.Sprintf("conflicting values for attribute %q of %q: %s (ours), %s (theirs)",
+		c.Key, c.File, side(c.Ours), side(c.Theirs))
+}
+
+// Merge performs a three-way merge of two tag maps that were both changed
+// from base. A tag or attribute added on either side is kept, and one
+// removed on either side is removed.
+func Merge(base, ours, theirs *TM) (*TM, []*MergeConflict) {
+	result := New()
+	conflicts := []*MergeConflict{}
+
+	fileSet := make(map[string]bool)
+	for _, tm := range []*TM{base, ours, theirs} {
+		for _, file := range tm.ListFiles() {
+			fileSet[file] = true
+		}
+	}
+
+	for _, file := range sortedSet(fileSet) {
+		tagSet := make(map[string]bool)
+		for _, tm := range []*TM{base, ours, theirs} {
+			for _, tag := range tm.FileToTag[file] {
+				tagSet[tag] = true
+			}
+		}
+		for _, tag := range sortedSet(tagSet) {
+			inBase := base.FileToTag.HasValue(file, tag)
+			inOurs := ours.FileToTag.HasValue(file, tag)
+			inTheirs := theirs.FileToTag.HasValue(file, tag)
+			if (inOurs && inTheirs) || (!inBase && (inOurs || inTheirs)) {
+				result.Add(file, tag)
+			}
+		}
+
+		keySet := make(map[string]bool)
+		for _, tm := range []*TM{base, ours, theirs} {
+			for key := range tm.FileToAttr[file] {
+				keySet[key] = true
+			}
+		}
+		for _, key := range sortedSet(keySet) {
+			b, _ := base.Attr(file, key)
+			o, _ := ours.Attr(file, key)
+			t, _ := theirs.Attr(file, key)
+
+			v := o
+			switch {
+			case o == t, b == t:
+			case b == o:
+				v = t
+			default:
+				conflicts = append(conflicts, &MergeConflict{File: file, Key: key, Ours: o, Theirs: t})
+			}
+			if v != nil {
+				result.SetAttr(file, key, v)
+			}
+		}
+
+		if !result.Has(file) {
+			continue
+		}
+		bfp, _ := base.Fingerprint(file)
+		fp, ok := ours.Fingerprint(file)
+		if tfp, tok := theirs.Fingerprint(file); tok && (!ok || fp.Hash == bfp.Hash) {
+			fp, ok = tfp, true
+		}
+		if ok {
+			result.SetFingerprint(file, fp)
+		}
+	}
+
+	return result, conflicts
+}
+
+func sortedSet(set map[string]bool) []string {
+	result := make([]string, 0, len(set))
+	for s := range set {
+		result = append(result, s)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,88 @@
+package tagmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("Merge", func() {
+
+	var base, ours, theirs *tagmap.TM
+
+	BeforeEach(func() {
+		base = tagmap.New()
+		base.Add("foo", "tag1")
+		base.Add("foo", "tag2")
+		base.Add("bar", "tag1")
+		base.SetAttr("foo", "status", "draft")
+		base.SetAttr("foo", "owner", "alice")
+
+		ours = base.Clone()
+		theirs = base.Clone()
+	})
+
+	It("should keep tags added on either side", func() {
+		ours.Add("foo", "tag3")
+		theirs.Add("baz", "tag4")
+
+		merged, conflicts := tagmap.Merge(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.FileToTag).To(Equal(tagmap.StringListMap{
+			"foo": []string{"tag1", "tag2", "tag3"},
+			"bar": []string{"tag1"},
+			"baz": []string{"tag4"},
+		}))
+		Expect(merged.Verify()).To(BeEmpty())
+	})
+
+	It("should drop tags and files removed on either side", func() {
+		ours.Remove("foo", "tag2")
+		theirs.Clear("bar")
+
+		merged, conflicts := tagmap.Merge(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.FileToTag).To(Equal(tagmap.StringListMap{
+			"foo": []string{"tag1"},
+		}))
+	})
+
+	It("should take attribute changes from the side that made them", func() {
+		ours.SetAttr("foo", "status", "approved")
+		theirs.UnsetAttr("foo", "owner")
+		theirs.SetAttr("bar", "rev", float64(2))
+
+		merged, conflicts := tagmap.Merge(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.FileToAttr).To(Equal(map[string]tagmap.Attrs{
+			"foo": {"status": "approved"},
+			"bar": {"rev": float64(2)},
+		}))
+	})
+
+	It("should report attributes changed differently on both sides", func() {
+		ours.SetAttr("foo", "status", "approved")
+		theirs.SetAttr("foo", "status", "rejected")
+		ours.SetAttr("foo", "owner", "bob")
+		theirs.UnsetAttr("foo", "owner")
+
+		merged, conflicts := tagmap.Merge(base, ours, theirs)
+		Expect(conflicts).To(HaveLen(2))
+		Expect(conflicts[0].Key).To(Equal("owner"))
+		Expect(conflicts[0].Theirs).To(BeNil())
+		Expect(conflicts[1].Error()).To(Equal(`conflicting values for attribute "status" of "foo": approved (ours), rejected (theirs)`))
+		Expect(merged.FileToAttr["foo"]).To(Equal(tagmap.Attrs{"status": "approved", "owner": "bob"}))
+	})
+
+	It("should take a fingerprint updated on their side", func() {
+		base.SetFingerprint("foo", tagmap.Fingerprint{Hash: "old"})
+		ours.SetFingerprint("foo", tagmap.Fingerprint{Hash: "old"})
+		theirs.SetFingerprint("foo", tagmap.Fingerprint{Hash: "new"})
+
+		merged, _ := tagmap.Merge(base, ours, theirs)
+		fp, ok := merged.Fingerprint("foo")
+		Expect(ok).To(BeTrue())
+		Expect(fp.Hash).To(Equal("new"))
+	})
+
+})
@@ -0,0 +1,188 @@
+package tagmap
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+var ErrNewerVersion = errors.New("tag map was written by a newer version of ftag")
+
+type Migration struct {
+	From        int
+	Description string
+
+	// apply upgrades a map from version From to From+1, returning a
+	// description of each change made.
+	apply func(tm *TM, root string) []string
+}
+
+var migrations = []Migration{
+	{
+		From:        1,
+		Description: "store file names relative to the tag map directory",
+		apply:       relativizeFiles,
+	},
+	{
+		From:        2,
+		Description: "add file attributes",
+	},
+	{
+		From:        3,
+		Description: "add file content fingerprints",
+	},
+}
+
+type MigrationStep struct {
+	From, To    string
+	Description string
+	Changes     []string
+}
+
+func currentVersion() int {
+	v, err := parseVersion(TM_VERSION)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func parseVersion(version string) (int, error) {
+	if version == "" {
+		return 1, nil
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("unrecognized tag map version: %q", version)
+	}
+	return v, nil
+}
+
+func checkVersionWritable(tm *TM) error {
+	v, err := parseVersion(tm.Version)
+	if err != nil {
+		return err
+	}
+	if v > currentVersion() {
+		return fmt.Errorf("%w: version %d, this ftag supports up to version %s; refusing to overwrite it", ErrNewerVersion, v, TM_VERSION)
+	}
+	return nil
+}
+
+// migrate upgrades tm one version at a time to TM_VERSION. A map from a
+// newer version is left as is so that it can be read, but not written.
+func migrate(tm *TM, root string) ([]MigrationStep, error) {
+	if tm.FileToTag == nil {
+		tm.FileToTag = make(StringListMap)
+	}
+	if tm.TagToFile == nil {
+		tm.TagToFile = make(StringListMap)
+	}
+	if tm.FileToAttr == nil {
+		tm.FileToAttr = make(map[string]Attrs)
+	}
+	if tm.FileToFingerprint == nil {
+		tm.FileToFingerprint = make(map[string]Fingerprint)
+	}
+
+	v, err := parseVersion(tm.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := []MigrationStep{}
+	for _, m := range migrations {
+		if m.From < v {
+			continue
+		}
+		if m.From >= currentVersion() {
+			break
+		}
+
+		step := MigrationStep{
+			From:        strconv.Itoa(m.From),
+			To:          strconv.Itoa(m.From + 1),
+			Description: m.Description,
+			Changes:     []string{},
+		}
+		if m.apply != nil {
+			step.Changes = m.apply(tm, root)
+		}
+		steps = append(steps, step)
+
+		v = m.From + 1
+		tm.Version = step.To
+	}
+
+	if v < currentVersion() {
+		return nil, fmt.Errorf("no migration from tag map version %d", v)
+	}
+
+	return steps, nil
+}
+
+// Version 1 maps stored file names exactly as they were typed. Re-key
+// them relative to the directory containing the map.
+func relativizeFiles(tm *TM, root string) []string {
+	changes := []string{}
+	rekey(tm, func(file string) string {
+		p := filepath.FromSlash(file)
+		if filepath.IsAbs(p) {
+			if rel, err := filepath.Rel(root, p); err == nil {
+				p = rel
+			}
+		}
+
+		key := path.Clean(filepath.ToSlash(p))
+		if key != file {
+			changes = append(changes, fmt.Sprintf("rename %s -> %s", file, key))
+		}
+		return key
+	})
+
+	sort.Strings(changes)
+	return changes
+}
+
+// rekey renames every file in tm, calling fn once per file.
+func rekey(tm *TM, fn func(file string) string) {
+	keys := make(map[string]string)
+	key := func(file string) string {
+		k, ok := keys[file]
+		if !ok {
+			k = fn(file)
+			keys[file] = k
+		}
+		return k
+	}
+
+	fileToTag := tm.FileToTag
+	fileToAttr := tm.FileToAttr
+	fileToFingerprint := tm.FileToFingerprint
+	tm.FileToTag = make(StringListMap)
+	tm.TagToFile = make(StringListMap)
+	tm.FileToAttr = make(map[string]Attrs)
+	tm.FileToFingerprint = make(map[string]Fingerprint)
+
+	for file, tags := range fileToTag {
+		file = key(file)
+		for _, tag := range tags {
+			tm.Add(file, tag)
+		}
+	}
+
+	for file, attrs := range fileToAttr {
+		file = key(file)
+		for key, value := range attrs {
+			tm.SetAttr(file, key, value)
+		}
+	}
+
+	for file, fp := range fileToFingerprint {
+		tm.SetFingerprint(key(file), fp)
+	}
+}
@@ -0,0 +1,61 @@
+package tagmap
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTagExists = errors.New("tag already exists")
+
+// RenameTag replaces tag from with to on every file that has it, and
+// returns those files. Renaming to a tag already in use fails with
+// ErrTagExists unless merge is set, in which case the two are combined.
+func RenameTag(tx Tx, from, to string, merge bool) ([]string, error) {
+	if from == to {
+		return nil, fmt.Errorf("cannot rename tag %q to itself", from)
+	}
+
+	if !merge {
+		files, err := tx.FilesFor(to)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			return nil, fmt.Errorf("%w: %q is on %d files", ErrTagExists, to, len(files))
+		}
+	}
+
+	return MergeTags(tx, []string{from}, to)
+}
+
+// MergeTags replaces each of tags with into on every file that has any of
+// them, and returns those files, sorted. Each of tags must be in use.
+func MergeTags(tx Tx, tags []string, into string) ([]string, error) {
+	fileSet := make(map[string]bool)
+	for _, tag := range tags {
+		if tag == into {
+			continue
+		}
+
+		files, err := tx.FilesFor(tag)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no files are tagged %q", tag)
+		}
+
+		for _, file := range files {
+			// Add before removing, so the file is never dropped as untagged.
+			if err := tx.Add(file, into); err != nil {
+				return nil, err
+			}
+			if err := tx.Remove(file, tag); err != nil {
+				return nil, err
+			}
+			fileSet[file] = true
+		}
+	}
+
+	return sortedSet(fileSet), nil
+}
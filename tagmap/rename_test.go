@@ -0,0 +1,82 @@
+package tagmap_test
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("Tag renaming", func() {
+
+	var tm *tagmap.TM
+	var tx tagmap.Tx
+
+	BeforeEach(func() {
+		tm = tagmap.New()
+		tm.Add("foo", "a")
+		tm.Add("foo", "b")
+		tm.Add("bar", "b")
+		tm.Add("baz", "c")
+		tx = tagmap.NewMapTx(tm)
+	})
+
+	Describe("RenameTag", func() {
+
+		It("should rename a tag on every file", func() {
+			files, err := tagmap.RenameTag(tx, "b", "d", false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"bar", "foo"}))
+			Expect(tm.FileToTag).To(Equal(tagmap.StringListMap{
+				"foo": []string{"a", "d"},
+				"bar": []string{"d"},
+				"baz": []string{"c"},
+			}))
+			Expect(tm.TagToFile).To(Equal(tagmap.StringListMap{
+				"a": []string{"foo"},
+				"c": []string{"baz"},
+				"d": []string{"bar", "foo"},
+			}))
+		})
+
+		It("should refuse to rename to a tag in use", func() {
+			_, err := tagmap.RenameTag(tx, "b", "a", false)
+			Expect(errors.Is(err, tagmap.ErrTagExists)).To(BeTrue())
+			Expect(tm.FileToTag["bar"]).To(Equal([]string{"b"}))
+		})
+
+		It("should combine with a tag in use when merging", func() {
+			files, err := tagmap.RenameTag(tx, "b", "a", true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"bar", "foo"}))
+			Expect(tm.FileToTag["foo"]).To(Equal([]string{"a"}))
+			Expect(tm.FileToTag["bar"]).To(Equal([]string{"a"}))
+			Expect(tm.TagToFile["a"]).To(ConsistOf("foo", "bar"))
+			Expect(tm.TagToFile).ToNot(HaveKey("b"))
+		})
+
+		It("should fail for a tag not in use", func() {
+			_, err := tagmap.RenameTag(tx, "nope", "d", false)
+			Expect(err).To(HaveOccurred())
+		})
+
+	})
+
+	Describe("MergeTags", func() {
+
+		It("should replace several tags with one", func() {
+			files, err := tagmap.MergeTags(tx, []string{"a", "b", "c"}, "c")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(Equal([]string{"bar", "foo"}))
+			Expect(tm.FileToTag).To(Equal(tagmap.StringListMap{
+				"foo": []string{"c"},
+				"bar": []string{"c"},
+				"baz": []string{"c"},
+			}))
+			Expect(tm.TagToFile).To(HaveLen(1))
+			Expect(tm.TagToFile["c"]).To(ConsistOf("foo", "bar", "baz"))
+		})
+
+	})
+
+})
@@ -0,0 +1,7 @@
+package tagmap
+
+import (
+	_ "modernc.org/sqlite" // pure Go, so ftag stays cgo-free
+)
+
+const sqliteDriverName = "sqlite"
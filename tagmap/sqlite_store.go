@@ -0,0 +1,259 @@
+package tagmap
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sqliteSchemaVersion = 1
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS files (
+		id       INTEGER PRIMARY KEY,
+		path     TEXT NOT NULL UNIQUE,
+		hash     TEXT,
+		size     INTEGER,
+		mod_time TEXT
+	)`,
+	`CREATE TABLE IF NOT EXISTS tags (
+		id   INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS file_tags (
+		file_id INTEGER NOT NULL REFERENCES files (id),
+		tag_id  INTEGER NOT NULL REFERENCES tags (id),
+		PRIMARY KEY (file_id, tag_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS file_tags_tag_id ON file_tags (tag_id)`,
+	`CREATE TABLE IF NOT EXISTS file_attrs (
+		file_id INTEGER NOT NULL REFERENCES files (id),
+		key     TEXT NOT NULL,
+		value   TEXT NOT NULL,
+		PRIMARY KEY (file_id, key)
+	)`,
+}
+
+// SQLiteStore keeps the tag map in an SQLite database with indexed file
+// and tag tables. Transactions query and change individual rows, so the
+// whole map is never loaded or rewritten.
+type SQLiteStore struct {
+	path string
+
+	LockTimeout time.Duration
+}
+
+func NewSQLiteStore(path string) *SQLiteStore {
+	if path == "" {
+		panic("path required")
+	}
+
+	return &SQLiteStore{
+		path:        path,
+		LockTimeout: DefaultLockTimeout,
+	}
+}
+
+func (ss *SQLiteStore) View(fn func(tx Tx) error) error {
+	return ss.transact(false, func(tx *sql.Tx) error {
+		return fn(&sqliteTx{tx: tx})
+	})
+}
+
+func (ss *SQLiteStore) Update(fn func(tx Tx) error) error {
+	return ss.transact(true, func(tx *sql.Tx) error {
+		return fn(&sqliteTx{tx: tx})
+	})
+}
+
+func (ss *SQLiteStore) Load() (*TM, error) {
+	var tm *TM
+	err := ss.transact(false, func(tx *sql.Tx) error {
+		var err error
+		tm, err = ss.load(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tm, nil
+}
+
+// Put writes only the rows that differ from what is stored.
+func (ss *SQLiteStore) Put(tm *TM) error {
+	return ss.transact(true, func(tx *sql.Tx) error {
+		current, err := ss.load(tx)
+		if err != nil {
+			return err
+		}
+		return putChanges(&sqliteTx{tx: tx}, current, tm)
+	})
+}
+
+// transact runs fn in a transaction. A write transaction takes SQLite's
+// write lock up front, waiting up to LockTimeout for other writers.
+func (ss *SQLiteStore) transact(write bool, fn func(tx *sql.Tx) error) error {
+	db, err := sql.Open(sqliteDriverName, ss.path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("PRAGMA busy_timeout = %d", ss.LockTimeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+
+	err = ss.ensureSchema(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	// database/sql can't begin an IMMEDIATE transaction, so take the
+	// write lock with a no-op write before anything is read.
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if write {
+		_, err = tx.Exec("DELETE FROM meta WHERE 0")
+		if err != nil {
+			tx.Rollback()
+			if isSQLiteBusy(err) {
+				return fmt.Errorf("%w: %s is held by another process (waited %s)", ErrLockTimeout, ss.path, ss.LockTimeout)
+			}
+			return err
+		}
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isSQLiteBusy recognizes SQLITE_BUSY by its message, which is the same
+// across drivers.
+func isSQLiteBusy(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "database is locked") || strings.Contains(msg, "SQLITE_BUSY")
+}
+
+func (ss *SQLiteStore) ensureSchema(ctx context.Context, conn *sql.Conn) error {
+	for _, stmt := range sqliteSchema {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	var version string
+	err := conn.QueryRowContext(ctx, "SELECT value FROM meta WHERE key = 'schema_version'").Scan(&version)
+	if err == sql.ErrNoRows {
+		_, err = conn.ExecContext(ctx, "INSERT OR IGNORE INTO meta (key, value) VALUES ('schema_version', ?)", strconv.Itoa(sqliteSchemaVersion))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return fmt.Errorf("unrecognized tag database schema version: %q", version)
+	}
+	if v > sqliteSchemaVersion {
+		return fmt.Errorf("%w: %s has schema version %d, this ftag supports up to version %d", ErrNewerVersion, ss.path, v, sqliteSchemaVersion)
+	}
+
+	return nil
+}
+
+func (ss *SQLiteStore) load(tx *sql.Tx) (*TM, error) {
+	tm := New()
+
+	rows, err := tx.Query(`
+		SELECT f.path, t.name
+		FROM file_tags ft
+		JOIN files f ON f.id = ft.file_id
+		JOIN tags t ON t.id = ft.tag_id
+		ORDER BY f.path, t.name`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var file, tag string
+		if err := rows.Scan(&file, &tag); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tm.Add(file, tag)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(`
+		SELECT f.path, a.key, a.value
+		FROM file_attrs a
+		JOIN files f ON f.id = a.file_id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var file, key, value string
+		if err := rows.Scan(&file, &key, &value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("attribute %s of %s: %s", key, file, err)
+		}
+		tm.SetAttr(file, key, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query("SELECT path, hash, size, mod_time FROM files WHERE hash IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var file, modTime string
+		var fp Fingerprint
+		if err := rows.Scan(&file, &fp.Hash, &fp.Size, &modTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		fp.ModTime, _ = time.Parse(time.RFC3339Nano, modTime)
+		tm.SetFingerprint(file, fp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tm, nil
+}
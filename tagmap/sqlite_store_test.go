@@ -0,0 +1,243 @@
+package tagmap_test
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var _ = Describe("SQLiteStore", func() {
+
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, ".ftag.db")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	update := func(fn func(tx tagmap.Tx)) {
+		err := tagmap.NewSQLiteStore(path).Update(func(tx tagmap.Tx) error {
+			fn(tx)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	load := func() *tagmap.TM {
+		tm, err := tagmap.NewSQLiteStore(path).Load()
+		Expect(err).ToNot(HaveOccurred())
+		return tm
+	}
+
+	It("should load an empty map from a new database", func() {
+		tm := load()
+		Expect(tm.ListFiles()).To(BeEmpty())
+	})
+
+	It("should store tags, attributes and fingerprints", func() {
+		modTime := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.Add("foo", "tag2")).To(Succeed())
+			Expect(tx.Add("bar", "tag1")).To(Succeed())
+			Expect(tx.SetAttr("foo", "rev", float64(2))).To(Succeed())
+			Expect(tx.SetAttr("bar", "ok", true)).To(Succeed())
+			Expect(tx.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h", Size: 3, ModTime: modTime})).To(Succeed())
+		})
+
+		tm := load()
+		Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1", "tag2"}))
+		Expect(tm.FileToTag["bar"]).To(Equal([]string{"tag1"}))
+		Expect(tm.TagToFile["tag1"]).To(Equal([]string{"bar", "foo"}))
+		Expect(tm.FileToAttr["foo"]).To(Equal(tagmap.Attrs{"rev": float64(2)}))
+		Expect(tm.FileToAttr["bar"]).To(Equal(tagmap.Attrs{"ok": true}))
+
+		fp, ok := tm.Fingerprint("foo")
+		Expect(ok).To(BeTrue())
+		Expect(fp.Hash).To(Equal("h"))
+		Expect(fp.Size).To(Equal(int64(3)))
+		Expect(fp.ModTime.Equal(modTime)).To(BeTrue())
+	})
+
+	It("should fingerprint a file before it is tagged", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h", Size: 3})).To(Succeed())
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+		})
+
+		fp, ok := load().Fingerprint("foo")
+		Expect(ok).To(BeTrue())
+		Expect(fp.Hash).To(Equal("h"))
+	})
+
+	It("should apply removals, replacements and moves", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.Add("foo", "tag2")).To(Succeed())
+			Expect(tx.Add("bar", "tag3")).To(Succeed())
+			Expect(tx.SetAttr("foo", "status", "draft")).To(Succeed())
+			Expect(tx.SetAttr("foo", "owner", "alice")).To(Succeed())
+		})
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Remove("foo", "tag1")).To(Succeed())
+			Expect(tx.SetAttr("foo", "status", "approved")).To(Succeed())
+			Expect(tx.UnsetAttr("foo", "owner")).To(Succeed())
+			Expect(tx.Move("foo", "baz")).To(BeTrue())
+			Expect(tx.Clear("bar")).To(Succeed())
+		})
+
+		tm := load()
+		Expect(tm.FileToTag).To(Equal(tagmap.StringListMap{
+			"baz": []string{"tag2"},
+		}))
+		Expect(tm.TagToFile).To(Equal(tagmap.StringListMap{
+			"tag2": []string{"baz"},
+		}))
+		Expect(tm.FileToAttr).To(Equal(map[string]tagmap.Attrs{
+			"baz": {"status": "approved"},
+		}))
+	})
+
+	It("should drop only the tags no file uses any more", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1", "tag2", "tag3")).To(Succeed())
+			Expect(tx.Add("bar", "tag1", "tag2")).To(Succeed())
+		})
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Remove("foo", "tag1", "tag3")).To(Succeed())
+			Expect(tx.Clear("bar")).To(Succeed())
+		})
+
+		err := tagmap.NewSQLiteStore(path).View(func(tx tagmap.Tx) error {
+			Expect(tx.Files()).To(Equal([]string{"foo"}))
+			Expect(tx.Tags()).To(Equal([]string{"tag2"}))
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should answer queries without loading the whole map", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1", "tag2")).To(Succeed())
+			Expect(tx.Add("bar", "tag2")).To(Succeed())
+			Expect(tx.SetAttr("baz", "status", "draft")).To(Succeed())
+		})
+
+		err := tagmap.NewSQLiteStore(path).View(func(tx tagmap.Tx) error {
+			Expect(tx.Files()).To(Equal([]string{"bar", "baz", "foo"}))
+			Expect(tx.Tags()).To(Equal([]string{"tag1", "tag2"}))
+			Expect(tx.TagsFor("foo")).To(Equal([]string{"tag1", "tag2"}))
+			Expect(tx.FilesFor("tag1", "tag2")).To(Equal([]string{"bar", "foo"}))
+			Expect(tx.FilesWithAttr("status", "draft")).To(Equal([]string{"baz"}))
+			Expect(tx.Has("baz")).To(BeTrue())
+			Expect(tx.Has("nope")).To(BeFalse())
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should merge a moved file into an existing entry", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.SetAttr("foo", "k", "v")).To(Succeed())
+			Expect(tx.Add("bar", "tag2")).To(Succeed())
+			Expect(tx.Move("foo", "bar")).To(BeTrue())
+			Expect(tx.Move("nope", "bar")).To(BeFalse())
+		})
+
+		tm := load()
+		Expect(tm.FileToTag).To(Equal(tagmap.StringListMap{
+			"bar": []string{"tag1", "tag2"},
+		}))
+		Expect(tm.FileToAttr["bar"]).To(Equal(tagmap.Attrs{"k": "v"}))
+	})
+
+	It("should find nothing to repair in a consistent database", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			Expect(tx.Verify()).To(BeEmpty())
+			Expect(tx.Rebuild()).To(BeEmpty())
+		})
+	})
+
+	It("should write changes made after Load with Put", func() {
+		s := tagmap.NewSQLiteStore(path)
+		tm, err := s.Load()
+		Expect(err).ToNot(HaveOccurred())
+		tm.Add("foo", "tag1")
+		Expect(s.Put(tm)).To(Succeed())
+
+		Expect(load().FileToTag["foo"]).To(Equal([]string{"tag1"}))
+	})
+
+	It("should not store anything when the update function fails", func() {
+		failure := errors.New("nope")
+		err := tagmap.NewSQLiteStore(path).Update(func(tx tagmap.Tx) error {
+			Expect(tx.Add("foo", "tag1")).To(Succeed())
+			return failure
+		})
+		Expect(err).To(Equal(failure))
+		Expect(load().ListFiles()).To(BeEmpty())
+	})
+
+	It("should not lose concurrent updates", func() {
+		load()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				update(func(tx tagmap.Tx) {
+					Expect(tx.Add(fmt.Sprintf("file%d", i), "tag")).To(Succeed())
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(load().TagToFile["tag"]).To(HaveLen(10))
+	})
+
+	It("should time out while another update holds the lock", func() {
+		load()
+
+		locked := make(chan bool)
+		release := make(chan bool)
+		done := make(chan error)
+
+		go func() {
+			done <- tagmap.NewSQLiteStore(path).Update(func(tx tagmap.Tx) error {
+				locked <- true
+				<-release
+				return nil
+			})
+		}()
+		<-locked
+
+		s := tagmap.NewSQLiteStore(path)
+		s.LockTimeout = 100 * time.Millisecond
+		err := s.Update(func(tx tagmap.Tx) error {
+			return nil
+		})
+		Expect(errors.Is(err, tagmap.ErrLockTimeout)).To(BeTrue())
+
+		close(release)
+		Expect(<-done).ToNot(HaveOccurred())
+	})
+
+})
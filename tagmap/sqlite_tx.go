@@ -0,0 +1,397 @@
+package tagmap
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type sqliteTx struct {
+	tx *sql.Tx
+}
+
+func (st *sqliteTx) strings(query string, args ...interface{}) ([]string, error) {
+	rows, err := st.tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func (st *sqliteTx) ids(query string, args ...interface{}) ([]interface{}, error) {
+	rows, err := st.tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []interface{}{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result = append(result, id)
+	}
+	return result, rows.Err()
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+func (st *sqliteTx) Files() ([]string, error) {
+	return st.strings("SELECT path FROM files ORDER BY path")
+}
+
+func (st *sqliteTx) Has(file string) (bool, error) {
+	var n int
+	err := st.tx.QueryRow(`
+		SELECT count(*) FROM files f
+		WHERE f.path = ?
+		AND (EXISTS (SELECT 1 FROM file_tags WHERE file_id = f.id)
+			OR EXISTS (SELECT 1 FROM file_attrs WHERE file_id = f.id))`, file).Scan(&n)
+	return n > 0, err
+}
+
+func (st *sqliteTx) Tags() ([]string, error) {
+	return st.strings("SELECT name FROM tags ORDER BY name")
+}
+
+func (st *sqliteTx) TagsFor(file string) ([]string, error) {
+	return st.strings(`
+		SELECT t.name FROM tags t
+		JOIN file_tags ft ON ft.tag_id = t.id
+		JOIN files f ON f.id = ft.file_id
+		WHERE f.path = ?
+		ORDER BY t.name`, file)
+}
+
+func (st *sqliteTx) FilesFor(tags ...string) ([]string, error) {
+	if len(tags) == 0 {
+		return []string{}, nil
+	}
+
+	return st.strings(`
+		SELECT DISTINCT f.path FROM files f
+		JOIN file_tags ft ON ft.file_id = f.id
+		JOIN tags t ON t.id = ft.tag_id
+		WHERE t.name IN (`+placeholders(len(tags))+`)
+		ORDER BY f.path`, stringArgs(tags)...)
+}
+
+func (st *sqliteTx) Attrs(file string) (Attrs, error) {
+	rows, err := st.tx.Query(`
+		SELECT a.key, a.value FROM file_attrs a
+		JOIN files f ON f.id = a.file_id
+		WHERE f.path = ?`, file)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attrs := make(Attrs)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, err
+		}
+		attrs[key] = v
+	}
+	return attrs, rows.Err()
+}
+
+func (st *sqliteTx) FilesWithAttr(key string, value interface{}) ([]string, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return st.strings(`
+		SELECT f.path FROM files f
+		JOIN file_attrs a ON a.file_id = f.id
+		WHERE a.key = ? AND a.value = ?
+		ORDER BY f.path`, key, string(b))
+}
+
+func (st *sqliteTx) Fingerprint(file string) (Fingerprint, bool, error) {
+	var fp Fingerprint
+	var hash, modTime sql.NullString
+	var size sql.NullInt64
+	err := st.tx.QueryRow("SELECT hash, size, mod_time FROM files WHERE path = ?", file).Scan(&hash, &size, &modTime)
+	if err == sql.ErrNoRows || (err == nil && !hash.Valid) {
+		return fp, false, nil
+	}
+	if err != nil {
+		return fp, false, err
+	}
+
+	fp.Hash = hash.String
+	fp.Size = size.Int64
+	fp.ModTime, _ = time.Parse(time.RFC3339Nano, modTime.String)
+	return fp, true, nil
+}
+
+func (st *sqliteTx) fileID(file string) (int64, error) {
+	_, err := st.tx.Exec("INSERT OR IGNORE INTO files (path) VALUES (?)", file)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = st.tx.QueryRow("SELECT id FROM files WHERE path = ?", file).Scan(&id)
+	return id, err
+}
+
+func (st *sqliteTx) tagID(tag string) (int64, error) {
+	_, err := st.tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = st.tx.QueryRow("SELECT id FROM tags WHERE name = ?", tag).Scan(&id)
+	return id, err
+}
+
+// prune deletes the file row when the file has neither tags nor
+// attributes left, and those of tagIDs, the tags just taken from it, that
+// no other file uses. Only those tags are checked, so that removing tags
+// from many files doesn't scan every tag each time.
+func (st *sqliteTx) prune(file string, tagIDs []interface{}) error {
+	_, err := st.tx.Exec(`
+		DELETE FROM files
+		WHERE path = ?
+		AND NOT EXISTS (SELECT 1 FROM file_tags WHERE file_id = files.id)
+		AND NOT EXISTS (SELECT 1 FROM file_attrs WHERE file_id = files.id)`, file)
+	if err != nil || len(tagIDs) == 0 {
+		return err
+	}
+
+	_, err = st.tx.Exec(`
+		DELETE FROM tags
+		WHERE id IN (`+placeholders(len(tagIDs))+`)
+		AND NOT EXISTS (SELECT 1 FROM file_tags WHERE tag_id = tags.id)`, tagIDs...)
+	return err
+}
+
+func (st *sqliteTx) Add(file string, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	id, err := st.fileID(file)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		tagID, err := st.tagID(tag)
+		if err != nil {
+			return err
+		}
+		_, err = st.tx.Exec("INSERT OR IGNORE INTO file_tags (file_id, tag_id) VALUES (?, ?)", id, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (st *sqliteTx) Remove(file string, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	tagIDs, err := st.ids("SELECT id FROM tags WHERE name IN ("+placeholders(len(tags))+")", stringArgs(tags)...)
+	if err != nil || len(tagIDs) == 0 {
+		return err
+	}
+
+	args := append([]interface{}{file}, tagIDs...)
+	_, err = st.tx.Exec(`
+		DELETE FROM file_tags
+		WHERE file_id = (SELECT id FROM files WHERE path = ?)
+		AND tag_id IN (`+placeholders(len(tagIDs))+`)`, args...)
+	if err != nil {
+		return err
+	}
+
+	return st.prune(file, tagIDs)
+}
+
+func (st *sqliteTx) Clear(file string) error {
+	tagIDs, err := st.ids("SELECT tag_id FROM file_tags WHERE file_id = (SELECT id FROM files WHERE path = ?)", file)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		"DELETE FROM file_tags WHERE file_id = (SELECT id FROM files WHERE path = ?)",
+		"DELETE FROM file_attrs WHERE file_id = (SELECT id FROM files WHERE path = ?)",
+		"DELETE FROM files WHERE path = ?",
+	} {
+		if _, err := st.tx.Exec(stmt, file); err != nil {
+			return err
+		}
+	}
+
+	return st.prune(file, tagIDs)
+}
+
+func (st *sqliteTx) Move(from, to string) (bool, error) {
+	has, err := st.Has(from)
+	if err != nil || !has {
+		return false, err
+	}
+
+	var exists int
+	err = st.tx.QueryRow("SELECT count(*) FROM files WHERE path = ?", to).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists == 0 {
+		_, err = st.tx.Exec("UPDATE files SET path = ? WHERE path = ?", to, from)
+		return err == nil, err
+	}
+
+	// Merge into the existing entry, as TM.Move does.
+	for _, stmt := range []string{
+		`INSERT OR IGNORE INTO file_tags (file_id, tag_id)
+			SELECT (SELECT id FROM files WHERE path = ?2), tag_id FROM file_tags
+			WHERE file_id = (SELECT id FROM files WHERE path = ?1)`,
+		`INSERT OR REPLACE INTO file_attrs (file_id, key, value)
+			SELECT (SELECT id FROM files WHERE path = ?2), key, value FROM file_attrs
+			WHERE file_id = (SELECT id FROM files WHERE path = ?1)`,
+		`UPDATE files SET (hash, size, mod_time) =
+			(SELECT hash, size, mod_time FROM files WHERE path = ?1)
+			WHERE path = ?2 AND (SELECT hash FROM files WHERE path = ?1) IS NOT NULL`,
+	} {
+		if _, err := st.tx.Exec(stmt, from, to); err != nil {
+			return false, err
+		}
+	}
+
+	return true, st.Clear(from)
+}
+
+func (st *sqliteTx) SetAttr(file, key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	id, err := st.fileID(file)
+	if err != nil {
+		return err
+	}
+
+	_, err = st.tx.Exec("INSERT OR REPLACE INTO file_attrs (file_id, key, value) VALUES (?, ?, ?)", id, key, string(b))
+	return err
+}
+
+func (st *sqliteTx) UnsetAttr(file, key string) error {
+	_, err := st.tx.Exec(`
+		DELETE FROM file_attrs
+		WHERE file_id = (SELECT id FROM files WHERE path = ?) AND key = ?`, file, key)
+	if err != nil {
+		return err
+	}
+
+	return st.prune(file, nil)
+}
+
+func (st *sqliteTx) SetFingerprint(file string, fp Fingerprint) error {
+	id, err := st.fileID(file)
+	if err != nil {
+		return err
+	}
+
+	_, err = st.tx.Exec("UPDATE files SET hash = ?, size = ?, mod_time = ? WHERE id = ?",
+		fp.Hash, fp.Size, fp.ModTime.Format(time.RFC3339Nano), id)
+	return err
+}
+
+var sqliteIntegrityChecks = []struct {
+	query string
+	msg   string
+}{
+	{
+		`SELECT path FROM files f
+			WHERE NOT EXISTS (SELECT 1 FROM file_tags WHERE file_id = f.id)
+			AND NOT EXISTS (SELECT 1 FROM file_attrs WHERE file_id = f.id)`,
+		"file %q has no tags or attributes",
+	},
+	{
+		"SELECT name FROM tags t WHERE NOT EXISTS (SELECT 1 FROM file_tags WHERE tag_id = t.id)",
+		"tag %q is not used by any file",
+	},
+	{
+		"SELECT CAST(file_id AS TEXT) FROM file_tags WHERE file_id NOT IN (SELECT id FROM files) OR tag_id NOT IN (SELECT id FROM tags)",
+		"file_tags references a missing file or tag (file id %s)",
+	},
+	{
+		"SELECT CAST(file_id AS TEXT) FROM file_attrs WHERE file_id NOT IN (SELECT id FROM files)",
+		"file_attrs references a missing file (file id %s)",
+	},
+}
+
+func (st *sqliteTx) Verify() ([]error, error) {
+	result := make([]error, 0)
+	for _, check := range sqliteIntegrityChecks {
+		values, err := st.strings(check.query)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			result = append(result, integrityErrorf(check.msg, v))
+		}
+	}
+	return result, nil
+}
+
+func (st *sqliteTx) Rebuild() ([]error, error) {
+	problems, err := st.Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range []string{
+		"DELETE FROM file_tags WHERE file_id NOT IN (SELECT id FROM files) OR tag_id NOT IN (SELECT id FROM tags)",
+		"DELETE FROM file_attrs WHERE file_id NOT IN (SELECT id FROM files)",
+		`DELETE FROM files
+			WHERE NOT EXISTS (SELECT 1 FROM file_tags WHERE file_id = files.id)
+			AND NOT EXISTS (SELECT 1 FROM file_attrs WHERE file_id = files.id)`,
+		"DELETE FROM tags WHERE NOT EXISTS (SELECT 1 FROM file_tags WHERE tag_id = tags.id)",
+	} {
+		if _, err := st.tx.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,86 @@
+package tagmap
+
+import "sort"
+
+// TagCount is the number of files having a tag.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Files int    `json:"files"`
+}
+
+// TagPair is the number of files having both of two tags.
+type TagPair struct {
+	Tags  [2]string `json:"tags"`
+	Files int       `json:"files"`
+}
+
+type Stats struct {
+	Files int `json:"files"`
+	Tags  int `json:"tags"`
+	Attrs int `json:"attrs"`
+
+	TagCounts []TagCount `json:"tagCounts"`
+	TagPairs  []TagPair  `json:"tagPairs"`
+}
+
+// CountStats tallies the tags in a tag map, most used first, and the top
+// pairs of tags most often found on the same file, or all of them if top
+// is not positive.
+func CountStats(r Reader, top int) (*Stats, error) {
+	files, err := r.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{Files: len(files)}
+	counts := make(map[string]int)
+	pairs := make(map[[2]string]int)
+	for _, file := range files {
+		tags, err := r.TagsFor(file)
+		if err != nil {
+			return nil, err
+		}
+		attrs, err := r.Attrs(file)
+		if err != nil {
+			return nil, err
+		}
+		stats.Attrs += len(attrs)
+
+		for i, tag := range tags {
+			counts[tag]++
+			for _, other := range tags[i+1:] {
+				pairs[[2]string{tag, other}]++
+			}
+		}
+	}
+
+	stats.Tags = len(counts)
+	stats.TagCounts = make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		stats.TagCounts = append(stats.TagCounts, TagCount{Tag: tag, Files: n})
+	}
+	sort.Slice(stats.TagCounts, func(i, j int) bool {
+		a, b := stats.TagCounts[i], stats.TagCounts[j]
+		return a.Files > b.Files || (a.Files == b.Files && a.Tag < b.Tag)
+	})
+
+	stats.TagPairs = make([]TagPair, 0, len(pairs))
+	for tags, n := range pairs {
+		stats.TagPairs = append(stats.TagPairs, TagPair{Tags: tags, Files: n})
+	}
+	sort.Slice(stats.TagPairs, func(i, j int) bool {
+		a, b := stats.TagPairs[i], stats.TagPairs[j]
+		if a.Files != b.Files {
+			return a.Files > b.Files
+		}
+		if a.Tags[0] != b.Tags[0] {
+			return a.Tags[0] < b.Tags[0]
+		}
+		return a.Tags[1] < b.Tags[1]
+	})
+	if top > 0 && len(stats.TagPairs) > top {
+		stats.TagPairs = stats.TagPairs[:top]
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,54 @@
+package tagmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("CountStats", func() {
+
+	var tm *tagmap.TM
+
+	BeforeEach(func() {
+		tm = tagmap.New()
+		tm.Add("foo", "a")
+		tm.Add("foo", "b")
+		tm.Add("foo", "c")
+		tm.Add("bar", "a")
+		tm.Add("bar", "b")
+		tm.Add("baz", "b")
+		tm.SetAttr("baz", "k", "v")
+	})
+
+	It("should count files, tags and attributes", func() {
+		stats, err := tagmap.CountStats(tagmap.NewMapTx(tm), 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Files).To(Equal(3))
+		Expect(stats.Tags).To(Equal(3))
+		Expect(stats.Attrs).To(Equal(1))
+		Expect(stats.TagCounts).To(Equal([]tagmap.TagCount{
+			{Tag: "b", Files: 3},
+			{Tag: "a", Files: 2},
+			{Tag: "c", Files: 1},
+		}))
+	})
+
+	It("should list the pairs of tags most often on the same file", func() {
+		stats, err := tagmap.CountStats(tagmap.NewMapTx(tm), 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.TagPairs).To(Equal([]tagmap.TagPair{
+			{Tags: [2]string{"a", "b"}, Files: 2},
+			{Tags: [2]string{"a", "c"}, Files: 1},
+		}))
+	})
+
+	It("should count an empty map", func() {
+		stats, err := tagmap.CountStats(tagmap.NewMapTx(tagmap.New()), 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Files).To(Equal(0))
+		Expect(stats.TagCounts).To(BeEmpty())
+		Expect(stats.TagPairs).To(BeEmpty())
+	})
+
+})
@@ -1,6 +1,27 @@
 package tagmap
 
 type Store interface {
+	// View runs fn with read access to the tag map.
+	View(fn func(tx Tx) error) error
+
+	// Update runs fn with write access to the tag map, excluding other
+	// writers for the duration, and applies its changes as one batch.
+	// Nothing is stored if fn fails.
+	Update(fn func(tx Tx) error) error
+
+	// Load and Put read and replace the whole tag map at once.
 	Load() (*TM, error)
 	Put(tm *TM) error
 }
+
+// Migrator is implemented by stores with a versioned format that can be
+// upgraded in place.
+type Migrator interface {
+	Migrate(dryRun bool) ([]MigrationStep, error)
+}
+
+// Formatter is implemented by stores kept in a text file with a canonical
+// form.
+type Formatter interface {
+	Format(check bool) (bool, error)
+}
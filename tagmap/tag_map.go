@@ -4,24 +4,35 @@ import (
 	"sort"
 )
 
-const TM_VERSION = "1"
+const TM_VERSION = "4"
 
 type TM struct {
-	Version   string        `json:"version"`
-	FileToTag StringListMap `json:"fileToTag"`
-	TagToFile StringListMap `json:"tagToFile"`
+	Version           string                 `json:"version"`
+	FileToTag         StringListMap          `json:"fileToTag"`
+	TagToFile         StringListMap          `json:"tagToFile"`
+	FileToAttr        map[string]Attrs       `json:"fileToAttr,omitempty"`
+	FileToFingerprint map[string]Fingerprint `json:"fileToFingerprint,omitempty"`
 }
 
 func New() *TM {
 	return &TM{
-		Version:   TM_VERSION,
-		FileToTag: make(StringListMap),
-		TagToFile: make(StringListMap),
+		Version:    TM_VERSION,
+		FileToTag:  make(StringListMap),
+		TagToFile:  make(StringListMap),
+		FileToAttr: make(map[string]Attrs),
+
+		FileToFingerprint: make(map[string]Fingerprint),
 	}
 }
 
 func (tm *TM) ListFiles() []string {
-	return tm.FileToTag.Keys()
+	files := tm.FileToTag.Keys()
+	for file := range tm.FileToAttr {
+		if _, ok := tm.FileToTag[file]; !ok {
+			files = append(files, file)
+		}
+	}
+	return files
 }
 
 func (tm *TM) FilesFor(tags ...string) []string {
@@ -58,10 +69,22 @@ func (tm *TM) Add(file, tag string) {
 func (tm *TM) Remove(file, tag string) bool {
 	found1 := tm.FileToTag.RemoveFirst(file, tag)
 	found2 := tm.TagToFile.RemoveFirst(tag, file)
+	tm.prune(file)
 	return found1 || found2
 }
 
+// prune drops the fingerprint of a file that no longer has tags or
+// attributes.
+func (tm *TM) prune(file string) {
+	if !tm.Has(file) {
+		delete(tm.FileToFingerprint, file)
+	}
+}
+
 func (tm *TM) Clear(file string) {
+	delete(tm.FileToAttr, file)
+	delete(tm.FileToFingerprint, file)
+
 	tags, ok := tm.FileToTag[file]
 	if !ok {
 		return
@@ -74,6 +97,71 @@ func (tm *TM) Clear(file string) {
 	}
 }
 
+func (tm *TM) Has(file string) bool {
+	_, hasTags := tm.FileToTag[file]
+	_, hasAttrs := tm.FileToAttr[file]
+	return hasTags || hasAttrs
+}
+
+// Move re-keys everything recorded for a file under a new name. It
+// returns false if nothing is recorded for the file.
+func (tm *TM) Move(from, to string) bool {
+	if !tm.Has(from) {
+		return false
+	}
+
+	// Re-map FileToAttr
+	for k, v := range tm.FileToAttr[from] {
+		tm.SetAttr(to, k, v)
+	}
+	delete(tm.FileToAttr, from)
+
+	// Re-map FileToFingerprint
+	if fp, ok := tm.FileToFingerprint[from]; ok {
+		delete(tm.FileToFingerprint, from)
+		tm.SetFingerprint(to, fp)
+	}
+
+	tags, ok := tm.FileToTag[from]
+	if !ok {
+		return true
+	}
+
+	// Re-map FileToTag
+	delete(tm.FileToTag, from)
+	tm.FileToTag.AddUnique(to, tags...)
+
+	// Re-map TagToFile
+	for _, tag := range tags {
+		tm.TagToFile.RemoveFirst(tag, from)
+		tm.TagToFile.AddUnique(tag, to)
+	}
+
+	return true
+}
+
+func (tm *TM) Clone() *TM {
+	clone := New()
+	clone.Version = tm.Version
+
+	for file, tags := range tm.FileToTag {
+		clone.FileToTag[file] = append([]string{}, tags...)
+	}
+	for tag, files := range tm.TagToFile {
+		clone.TagToFile[tag] = append([]string{}, files...)
+	}
+	for file, attrs := range tm.FileToAttr {
+		for k, v := range attrs {
+			clone.SetAttr(file, k, v)
+		}
+	}
+	for file, fp := range tm.FileToFingerprint {
+		clone.FileToFingerprint[file] = fp
+	}
+
+	return clone
+}
+
 func (tm *TM) Normalize() *TM {
 	tm = &(*tm) // clone
 
@@ -82,6 +170,9 @@ func (tm *TM) Normalize() *TM {
 	for _, tags := range tm.FileToTag {
 		sort.Strings(tags)
 	}
+	for _, files := range tm.TagToFile {
+		sort.Strings(files)
+	}
 
 	return tm
 }
@@ -144,6 +144,82 @@ var _ = Describe("TagMap", func() {
 
 	})
 
+	Describe("Move", func() {
+
+		It("should return false for a non-existent file", func() {
+			tm := tagmap.New()
+			Expect(tm.Move("foo", "bar")).To(BeFalse())
+		})
+
+		It("should re-key tags, attributes and fingerprints", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			tm.Add("foo", "tag2")
+			tm.Add("baz", "tag1")
+			tm.SetAttr("foo", "k", "v")
+			tm.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h", Size: 1})
+
+			Expect(tm.Move("foo", "bar")).To(BeTrue())
+
+			Expect(tm.FileToTag).ToNot(HaveKey("foo"))
+			Expect(tm.FileToTag["bar"]).To(Equal([]string{"tag1", "tag2"}))
+			Expect(tm.TagToFile["tag1"]).To(ConsistOf("baz", "bar"))
+			Expect(tm.TagToFile["tag2"]).To(Equal([]string{"bar"}))
+
+			Expect(tm.FileToAttr).ToNot(HaveKey("foo"))
+			Expect(tm.FileToAttr["bar"]).To(Equal(tagmap.Attrs{"k": "v"}))
+
+			_, ok := tm.Fingerprint("foo")
+			Expect(ok).To(BeFalse())
+			fp, ok := tm.Fingerprint("bar")
+			Expect(ok).To(BeTrue())
+			Expect(fp.Hash).To(Equal("h"))
+		})
+
+	})
+
+	Describe("Fingerprints", func() {
+
+		It("should be dropped with the last tag of a file", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			tm.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h"})
+			tm.Remove("foo", "tag1")
+			_, ok := tm.Fingerprint("foo")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should be dropped when a file is cleared", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			tm.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h"})
+			tm.Clear("foo")
+			_, ok := tm.Fingerprint("foo")
+			Expect(ok).To(BeFalse())
+		})
+
+	})
+
+	Describe("Clone", func() {
+
+		It("should copy without sharing lists", func() {
+			tm := tagmap.New()
+			tm.Add("foo", "tag1")
+			tm.SetAttr("foo", "k", "v")
+			tm.SetFingerprint("foo", tagmap.Fingerprint{Hash: "h"})
+
+			clone := tm.Clone()
+			Expect(clone).To(Equal(tm))
+
+			clone.Add("foo", "tag2")
+			clone.SetAttr("foo", "k", "w")
+			Expect(tm.FileToTag["foo"]).To(Equal([]string{"tag1"}))
+			Expect(tm.TagToFile).ToNot(HaveKey("tag2"))
+			Expect(tm.FileToAttr["foo"]["k"]).To(Equal("v"))
+		})
+
+	})
+
 	Describe("Normalize", func() {
 
 		It("should set the version", func() {
@@ -159,6 +235,13 @@ var _ = Describe("TagMap", func() {
 			tm.Normalize()
 			Expect(tm.FileToTag["foo"]).To(Equal([]string{"a", "b", "c"}))
 		})
+
+		It("should sort file lists", func() {
+			tm := tagmap.New()
+			tm.TagToFile["tag"] = []string{"b", "a", "c"}
+			tm.Normalize()
+			Expect(tm.TagToFile["tag"]).To(Equal([]string{"a", "b", "c"}))
+		})
 	})
 
 })
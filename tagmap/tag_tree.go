@@ -0,0 +1,159 @@
+package tagmap
+
+import (
+	"sort"
+	"strings"
+)
+
+const TagSeparator = "/"
+
+// TagNode is a node in the hierarchy formed by splitting tags on
+// TagSeparator, e.g. "project/alpha/design" is a descendant of "project".
+type TagNode struct {
+	Tag      string // the full tag path, empty for the root
+	Label    string // the last path segment
+	Tagged   bool   // whether Tag itself is a tag, rather than only an ancestor of one
+	Children []*TagNode
+}
+
+func SplitTag(tag string) []string {
+	parts := strings.Split(tag, TagSeparator)
+	segments := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			segments = append(segments, p)
+		}
+	}
+	return segments
+}
+
+func CleanTag(tag string) string {
+	return strings.Join(SplitTag(tag), TagSeparator)
+}
+
+func NewTagTree(tags []string) *TagNode {
+	root := &TagNode{}
+	for _, tag := range tags {
+		root.insert(tag)
+	}
+	root.sort()
+	return root
+}
+
+func (n *TagNode) insert(tag string) {
+	node := n
+	for _, segment := range SplitTag(tag) {
+		node = node.child(segment, true)
+	}
+	if node != n {
+		node.Tagged = true
+	}
+}
+
+func (n *TagNode) child(label string, create bool) *TagNode {
+	for _, c := range n.Children {
+		if c.Label == label {
+			return c
+		}
+	}
+	if !create {
+		return nil
+	}
+
+	tag := label
+	if n.Tag != "" {
+		tag = n.Tag + TagSeparator + label
+	}
+	c := &TagNode{Tag: tag, Label: label}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *TagNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Label < n.Children[j].Label
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+func (n *TagNode) Find(tag string) *TagNode {
+	segments := SplitTag(tag)
+	if len(segments) == 0 {
+		return nil
+	}
+
+	node := n
+	for _, segment := range segments {
+		node = node.child(segment, false)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+func (n *TagNode) Walk(fn func(node *TagNode)) {
+	fn(n)
+	for _, c := range n.Children {
+		c.Walk(fn)
+	}
+}
+
+// Tags lists the tags at or below this node.
+func (n *TagNode) Tags() []string {
+	tags := []string{}
+	n.Walk(func(node *TagNode) {
+		if node.Tagged {
+			tags = append(tags, node.Tag)
+		}
+	})
+	return tags
+}
+
+// TagsUnder lists tag and its descendant tags that are in use.
+func TagsUnder(r Reader, tag string) ([]string, error) {
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	node := NewTagTree(tags).Find(tag)
+	if node == nil {
+		return []string{}, nil
+	}
+	return node.Tags(), nil
+}
+
+// FilesUnder lists the files that have tag or any of its descendants.
+func FilesUnder(r Reader, tag string) ([]string, error) {
+	tags, err := TagsUnder(r, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return []string{}, nil
+	}
+	return r.FilesFor(tags...)
+}
+
+// RemoveUnder removes tag and its descendants from file, returning the
+// tags that were removed.
+func RemoveUnder(tx Tx, file, tag string) ([]string, error) {
+	tags, err := tx.TagsFor(file)
+	if err != nil {
+		return nil, err
+	}
+
+	node := NewTagTree(tags).Find(tag)
+	if node == nil {
+		return []string{}, nil
+	}
+
+	removed := node.Tags()
+	if err := tx.Remove(file, removed...); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
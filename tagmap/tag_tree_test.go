@@ -0,0 +1,109 @@
+package tagmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("TagTree", func() {
+
+	Describe("CleanTag", func() {
+
+		It("should drop empty segments", func() {
+			Expect(tagmap.CleanTag("/a//b/")).To(Equal("a/b"))
+			Expect(tagmap.CleanTag("a")).To(Equal("a"))
+			Expect(tagmap.CleanTag("//")).To(Equal(""))
+		})
+
+	})
+
+	Describe("NewTagTree", func() {
+
+		It("should nest tags by segment in sorted order", func() {
+			root := tagmap.NewTagTree([]string{"b", "a/y", "a/x/z"})
+			Expect(root.Children).To(HaveLen(2))
+
+			a := root.Children[0]
+			Expect(a.Tag).To(Equal("a"))
+			Expect(a.Tagged).To(BeFalse())
+			Expect(a.Children).To(HaveLen(2))
+			Expect(a.Children[0].Tag).To(Equal("a/x"))
+			Expect(a.Children[0].Children[0].Tag).To(Equal("a/x/z"))
+			Expect(a.Children[0].Children[0].Label).To(Equal("z"))
+			Expect(a.Children[1].Tag).To(Equal("a/y"))
+			Expect(a.Children[1].Tagged).To(BeTrue())
+
+			Expect(root.Children[1].Tag).To(Equal("b"))
+		})
+
+	})
+
+	Describe("Find", func() {
+
+		It("should return nil for a missing tag", func() {
+			root := tagmap.NewTagTree([]string{"a/b"})
+			Expect(root.Find("a/c")).To(BeNil())
+			Expect(root.Find("")).To(BeNil())
+		})
+
+		It("should find intermediate nodes", func() {
+			root := tagmap.NewTagTree([]string{"a/b/c"})
+			Expect(root.Find("a/b").Tags()).To(Equal([]string{"a/b/c"}))
+		})
+
+	})
+
+	Describe("TagsUnder", func() {
+
+		It("should list a tag and its descendants", func() {
+			tm := tagmap.New()
+			tm.Add("f", "project/alpha")
+			tm.Add("f", "project/alpha/design")
+			tm.Add("f", "project/alphabet")
+			tm.Add("f", "project/beta")
+			Expect(tagmap.TagsUnder(tagmap.NewMapTx(tm), "project/alpha")).To(Equal([]string{"project/alpha", "project/alpha/design"}))
+		})
+
+		It("should return an empty list for an unknown tag", func() {
+			tm := tagmap.New()
+			Expect(tagmap.TagsUnder(tagmap.NewMapTx(tm), "nope")).To(Equal([]string{}))
+		})
+
+	})
+
+	Describe("FilesUnder", func() {
+
+		It("should return files tagged with descendant tags", func() {
+			tm := tagmap.New()
+			tm.Add("a", "project/alpha/design")
+			tm.Add("b", "project/alpha")
+			tm.Add("c", "project/beta")
+			tx := tagmap.NewMapTx(tm)
+			Expect(tagmap.FilesUnder(tx, "project/alpha")).To(Equal([]string{"a", "b"}))
+			Expect(tagmap.FilesUnder(tx, "project")).To(Equal([]string{"a", "b", "c"}))
+			Expect(tagmap.FilesUnder(tx, "nope")).To(Equal([]string{}))
+		})
+
+	})
+
+	Describe("RemoveUnder", func() {
+
+		It("should remove a tag subtree from a file only", func() {
+			tm := tagmap.New()
+			tm.Add("a", "project/alpha")
+			tm.Add("a", "project/alpha/design")
+			tm.Add("a", "project/beta")
+			tm.Add("b", "project/alpha/design")
+
+			removed, err := tagmap.RemoveUnder(tagmap.NewMapTx(tm), "a", "project/alpha")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(Equal([]string{"project/alpha", "project/alpha/design"}))
+			Expect(tm.FileToTag["a"]).To(Equal([]string{"project/beta"}))
+			Expect(tm.TagToFile["project/alpha/design"]).To(Equal([]string{"b"}))
+			Expect(tm.TagToFile).ToNot(HaveKey("project/alpha"))
+		})
+
+	})
+
+})
@@ -0,0 +1,197 @@
+package tagmap
+
+import "sort"
+
+// Reader queries a tag map. File names are keys relative to the tag map,
+// and listings are sorted.
+type Reader interface {
+	Files() ([]string, error)
+	Has(file string) (bool, error)
+	Tags() ([]string, error)
+	TagsFor(file string) ([]string, error)
+	FilesFor(tags ...string) ([]string, error)
+	Attrs(file string) (Attrs, error)
+	FilesWithAttr(key string, value interface{}) ([]string, error)
+	Fingerprint(file string) (Fingerprint, bool, error)
+}
+
+// Tx reads and changes a tag map within a transaction opened by
+// Store.View or Store.Update.
+type Tx interface {
+	Reader
+
+	Add(file string, tags ...string) error
+	Remove(file string, tags ...string) error
+	Clear(file string) error
+	Move(from, to string) (bool, error)
+	SetAttr(file, key string, value interface{}) error
+	UnsetAttr(file, key string) error
+	SetFingerprint(file string, fp Fingerprint) error
+
+	// Verify reports inconsistencies in the store's index, and Rebuild
+	// repairs them, returning what was repaired.
+	Verify() ([]error, error)
+	Rebuild() ([]error, error)
+}
+
+// putChanges applies the differences between current and tm to tx.
+func putChanges(tx Tx, current, tm *TM) error {
+	for _, file := range current.ListFiles() {
+		if !tm.Has(file) {
+			if err := tx.Clear(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, file := range tm.ListFiles() {
+		added := []string{}
+		for _, tag := range tm.FileToTag[file] {
+			if !current.FileToTag.HasValue(file, tag) {
+				added = append(added, tag)
+			}
+		}
+		removed := []string{}
+		for _, tag := range current.FileToTag[file] {
+			if !tm.FileToTag.HasValue(file, tag) {
+				removed = append(removed, tag)
+			}
+		}
+
+		// Add before removing, so the file is never dropped as untagged.
+		if err := tx.Add(file, added...); err != nil {
+			return err
+		}
+
+		attrs := tm.FileToAttr[file]
+		for key, value := range attrs {
+			if old, ok := current.FileToAttr[file][key]; !ok || old != value {
+				if err := tx.SetAttr(file, key, value); err != nil {
+					return err
+				}
+			}
+		}
+		for key := range current.FileToAttr[file] {
+			if _, ok := attrs[key]; !ok {
+				if err := tx.UnsetAttr(file, key); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := tx.Remove(file, removed...); err != nil {
+			return err
+		}
+
+		if fp, ok := tm.Fingerprint(file); ok {
+			if old, ok := current.Fingerprint(file); !ok || old != fp {
+				if err := tx.SetFingerprint(file, fp); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// mapTx adapts an in-memory TM to Tx for stores that load and save the
+// whole map at once.
+type mapTx struct {
+	tm *TM
+}
+
+func NewMapTx(tm *TM) Tx {
+	return &mapTx{
+		tm: tm,
+	}
+}
+
+func (mt *mapTx) Files() ([]string, error) {
+	files := mt.tm.ListFiles()
+	sort.Strings(files)
+	return files, nil
+}
+
+func (mt *mapTx) Has(file string) (bool, error) {
+	return mt.tm.Has(file), nil
+}
+
+func (mt *mapTx) Tags() ([]string, error) {
+	tags := mt.tm.TagToFile.Keys()
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (mt *mapTx) TagsFor(file string) ([]string, error) {
+	tags := append([]string{}, mt.tm.FileToTag[file]...)
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (mt *mapTx) FilesFor(tags ...string) ([]string, error) {
+	return mt.tm.FilesFor(tags...), nil
+}
+
+func (mt *mapTx) Attrs(file string) (Attrs, error) {
+	attrs := make(Attrs)
+	for k, v := range mt.tm.FileToAttr[file] {
+		attrs[k] = v
+	}
+	return attrs, nil
+}
+
+func (mt *mapTx) FilesWithAttr(key string, value interface{}) ([]string, error) {
+	return mt.tm.FilesWithAttr(key, value), nil
+}
+
+func (mt *mapTx) Fingerprint(file string) (Fingerprint, bool, error) {
+	fp, ok := mt.tm.Fingerprint(file)
+	return fp, ok, nil
+}
+
+func (mt *mapTx) Add(file string, tags ...string) error {
+	for _, tag := range tags {
+		mt.tm.Add(file, tag)
+	}
+	return nil
+}
+
+func (mt *mapTx) Remove(file string, tags ...string) error {
+	for _, tag := range tags {
+		mt.tm.Remove(file, tag)
+	}
+	return nil
+}
+
+func (mt *mapTx) Clear(file string) error {
+	mt.tm.Clear(file)
+	return nil
+}
+
+func (mt *mapTx) Move(from, to string) (bool, error) {
+	return mt.tm.Move(from, to), nil
+}
+
+func (mt *mapTx) SetAttr(file, key string, value interface{}) error {
+	mt.tm.SetAttr(file, key, value)
+	return nil
+}
+
+func (mt *mapTx) UnsetAttr(file, key string) error {
+	mt.tm.UnsetAttr(file, key)
+	return nil
+}
+
+func (mt *mapTx) SetFingerprint(file string, fp Fingerprint) error {
+	mt.tm.SetFingerprint(file, fp)
+	return nil
+}
+
+func (mt *mapTx) Verify() ([]error, error) {
+	return mt.tm.Verify(), nil
+}
+
+func (mt *mapTx) Rebuild() ([]error, error) {
+	return mt.tm.Rebuild(), nil
+}
@@ -0,0 +1,42 @@
+package tagmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+)
+
+var _ = Describe("MapTx", func() {
+
+	It("should query and change the underlying map", func() {
+		tm := tagmap.New()
+		tx := tagmap.NewMapTx(tm)
+
+		Expect(tx.Add("foo", "tag1", "tag2")).To(Succeed())
+		Expect(tx.Add("bar", "tag2")).To(Succeed())
+		Expect(tx.SetAttr("foo", "k", "v")).To(Succeed())
+
+		Expect(tx.Files()).To(Equal([]string{"bar", "foo"}))
+		Expect(tx.Tags()).To(Equal([]string{"tag1", "tag2"}))
+		Expect(tx.TagsFor("foo")).To(Equal([]string{"tag1", "tag2"}))
+		Expect(tx.FilesFor("tag2")).To(Equal([]string{"bar", "foo"}))
+		Expect(tx.Attrs("foo")).To(Equal(tagmap.Attrs{"k": "v"}))
+
+		Expect(tx.Move("foo", "baz")).To(BeTrue())
+		Expect(tx.Remove("bar", "tag2")).To(Succeed())
+		Expect(tm.FileToTag).To(Equal(tagmap.StringListMap{
+			"baz": []string{"tag1", "tag2"},
+		}))
+	})
+
+	It("should not share attributes with the map", func() {
+		tm := tagmap.New()
+		tm.SetAttr("foo", "k", "v")
+
+		attrs, err := tagmap.NewMapTx(tm).Attrs("foo")
+		Expect(err).ToNot(HaveOccurred())
+		attrs["k"] = "w"
+		Expect(tm.FileToAttr["foo"]["k"]).To(Equal("v"))
+	})
+
+})
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package tagmap
+
+import (
+	"os"
+	"syscall"
+)
+
+const xattrSupported = true
+
+func getxattr(path, name string) ([]byte, error) {
+	size, err := syscall.Getxattr(path, name, nil)
+	for err == nil {
+		buf := make([]byte, size)
+		var n int
+		n, err = syscall.Getxattr(path, name, buf)
+		if err == syscall.ERANGE {
+			// grew between calls
+			size, err = syscall.Getxattr(path, name, nil)
+			continue
+		}
+		if err == nil {
+			return buf[:n], nil
+		}
+	}
+
+	if err == syscall.ENODATA {
+		return nil, nil
+	}
+	return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+}
+
+func setxattr(path, name string, value []byte) error {
+	err := syscall.Setxattr(path, name, value, 0)
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+	return nil
+}
+
+func removexattr(path, name string) error {
+	err := syscall.Removexattr(path, name)
+	if err != nil && err != syscall.ENODATA {
+		return &os.PathError{Op: "removexattr", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package tagmap
+
+import (
+	"os"
+)
+
+const xattrSupported = false
+
+func getxattr(path, name string) ([]byte, error) {
+	return nil, &os.PathError{Op: "getxattr", Path: path, Err: errXattrUnsupported}
+}
+
+func setxattr(path, name string, value []byte) error {
+	return &os.PathError{Op: "setxattr", Path: path, Err: errXattrUnsupported}
+}
+
+func removexattr(path, name string) error {
+	return &os.PathError{Op: "removexattr", Path: path, Err: errXattrUnsupported}
+}
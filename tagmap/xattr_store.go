@@ -0,0 +1,225 @@
+package tagmap
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+const (
+	XattrTags  = "user.ftag.tags"
+	XattrAttrs = "user.ftag.attrs"
+)
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// XattrStore keeps each file's tags in its own extended attributes, so
+// they travel with the file when it is copied or moved. Loading scans the
+// directory tree under root to build the map.
+type XattrStore struct {
+	root string
+
+	// OnSkip, if set, is told of each file or directory under root that
+	// couldn't be read and was left out of the map.
+	OnSkip func(err error)
+}
+
+func NewXattrStore(root string) *XattrStore {
+	if root == "" {
+		panic("root required")
+	}
+
+	return &XattrStore{
+		root: root,
+	}
+}
+
+func (xs *XattrStore) Load() (*TM, error) {
+	return xs.scan()
+}
+
+// Put writes the extended attributes of files whose tags or attributes
+// differ from what is stored, and removes them from files that no longer
+// have any.
+func (xs *XattrStore) Put(tm *TM) error {
+	current, err := xs.scan()
+	if err != nil {
+		return err
+	}
+
+	return xs.writeChanges(current, tm)
+}
+
+func (xs *XattrStore) View(fn func(tx Tx) error) error {
+	tm, err := xs.scan()
+	if err != nil {
+		return err
+	}
+
+	return fn(NewMapTx(tm))
+}
+
+// Update does not lock: writes only touch the files whose tags changed,
+// so concurrent updates of different files do not interfere.
+func (xs *XattrStore) Update(fn func(tx Tx) error) error {
+	current, err := xs.scan()
+	if err != nil {
+		return err
+	}
+
+	tm := current.Clone()
+	err = fn(NewMapTx(tm))
+	if err != nil {
+		return err
+	}
+
+	return xs.writeChanges(current, tm)
+}
+
+func (xs *XattrStore) writeChanges(current, tm *TM) error {
+	for _, file := range tm.ListFiles() {
+		err := xs.write(file, current, tm)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, file := range current.ListFiles() {
+		if tm.Has(file) {
+			continue
+		}
+		err := xs.write(file, current, tm)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (xs *XattrStore) scan() (*TM, error) {
+	if !xattrSupported {
+		return nil, &os.PathError{Op: "getxattr", Path: xs.root, Err: errXattrUnsupported}
+	}
+
+	tm := New()
+
+	// Only a failure at root fails the scan. Anything below it that
+	// can't be read, such as a directory without permission, a mount
+	// without user extended attributes or a malformed attribute, is
+	// skipped.
+	err := filepath.Walk(xs.root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if p == xs.root {
+				return err
+			}
+			xs.skip(err)
+			return nil
+		}
+		if fi.IsDir() && p != xs.root && fi.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(xs.root, p)
+		if err != nil {
+			return err
+		}
+
+		err = xs.read(p, filepath.ToSlash(rel), tm)
+		if err != nil && p != xs.root {
+			xs.skip(err)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tm, nil
+}
+
+func (xs *XattrStore) skip(err error) {
+	if xs.OnSkip != nil {
+		xs.OnSkip(err)
+	}
+}
+
+func (xs *XattrStore) read(p, file string, tm *TM) error {
+	b, err := getxattr(p, XattrTags)
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		var tags []string
+		if err := json.Unmarshal(b, &tags); err != nil {
+			return &os.PathError{Op: "read " + XattrTags, Path: p, Err: err}
+		}
+		for _, tag := range tags {
+			tm.Add(file, tag)
+		}
+	}
+
+	b, err = getxattr(p, XattrAttrs)
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		var attrs Attrs
+		if err := json.Unmarshal(b, &attrs); err != nil {
+			return &os.PathError{Op: "read " + XattrAttrs, Path: p, Err: err}
+		}
+		for k, v := range attrs {
+			tm.SetAttr(file, k, v)
+		}
+	}
+
+	return nil
+}
+
+func (xs *XattrStore) write(file string, current, tm *TM) error {
+	p := filepath.Join(xs.root, filepath.FromSlash(file))
+
+	tags := append([]string{}, tm.FileToTag[file]...)
+	sort.Strings(tags)
+	oldTags := append([]string{}, current.FileToTag[file]...)
+	sort.Strings(oldTags)
+
+	if !reflect.DeepEqual(tags, oldTags) {
+		err := writeXattr(p, XattrTags, len(tags) == 0, tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	attrs := tm.FileToAttr[file]
+	if !reflect.DeepEqual(attrs, current.FileToAttr[file]) {
+		err := writeXattr(p, XattrAttrs, len(attrs) == 0, attrs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeXattr(p, name string, remove bool, value interface{}) error {
+	if remove {
+		return removexattr(p, name)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return setxattr(p, name, b)
+}
@@ -0,0 +1,120 @@
+package tagmap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/troykinsella/ftag/tagmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+var _ = Describe("XattrStore", func() {
+
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ftag-test")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.Mkdir(filepath.Join(dir, "sub"), 0755)).To(Succeed())
+		for _, f := range []string{"a", "b", "sub/c"} {
+			Expect(ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0644)).To(Succeed())
+		}
+
+		err = tagmap.NewXattrStore(dir).Update(func(tx tagmap.Tx) error {
+			return nil
+		})
+		if err != nil {
+			Skip("extended attributes unavailable: " + err.Error())
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	update := func(fn func(tx tagmap.Tx)) {
+		err := tagmap.NewXattrStore(dir).Update(func(tx tagmap.Tx) error {
+			fn(tx)
+			return nil
+		})
+		if err != nil {
+			Skip("extended attributes unavailable: " + err.Error())
+		}
+	}
+
+	load := func() *tagmap.TM {
+		tm, err := tagmap.NewXattrStore(dir).Load()
+		Expect(err).ToNot(HaveOccurred())
+		return tm
+	}
+
+	It("should load an empty map from untagged files", func() {
+		Expect(load().ListFiles()).To(BeEmpty())
+	})
+
+	It("should store tags and attributes on the files", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("a", "tag1")).To(Succeed())
+			Expect(tx.Add("sub/c", "tag1")).To(Succeed())
+			Expect(tx.Add("sub/c", "tag2")).To(Succeed())
+			Expect(tx.SetAttr("a", "status", "approved")).To(Succeed())
+		})
+
+		tm := load()
+		Expect(tm.FileToTag["a"]).To(Equal([]string{"tag1"}))
+		Expect(tm.FileToTag["sub/c"]).To(ConsistOf("tag1", "tag2"))
+		Expect(tm.TagToFile["tag1"]).To(ConsistOf("a", "sub/c"))
+		Expect(tm.FileToAttr["a"]).To(Equal(tagmap.Attrs{"status": "approved"}))
+	})
+
+	It("should remove the attributes of cleared files", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("a", "tag1")).To(Succeed())
+			Expect(tx.SetAttr("a", "k", "v")).To(Succeed())
+		})
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Clear("a")).To(Succeed())
+		})
+
+		Expect(load().ListFiles()).To(BeEmpty())
+	})
+
+	It("should follow files that are renamed", func() {
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("a", "tag1")).To(Succeed())
+		})
+		Expect(os.Rename(filepath.Join(dir, "a"), filepath.Join(dir, "sub", "moved"))).To(Succeed())
+
+		Expect(load().FileToTag).To(Equal(tagmap.StringListMap{
+			"sub/moved": []string{"tag1"},
+		}))
+	})
+
+	It("should skip and report a directory it can't read", func() {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			Skip("directory permissions are not enforced")
+		}
+		update(func(tx tagmap.Tx) {
+			Expect(tx.Add("a", "tag1")).To(Succeed())
+			Expect(tx.Add("sub/c", "tag2")).To(Succeed())
+		})
+		Expect(os.Chmod(filepath.Join(dir, "sub"), 0)).To(Succeed())
+		defer os.Chmod(filepath.Join(dir, "sub"), 0755)
+
+		var skipped []error
+		s := tagmap.NewXattrStore(dir)
+		s.OnSkip = func(err error) {
+			skipped = append(skipped, err)
+		}
+		tm, err := s.Load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tm.ListFiles()).To(Equal([]string{"a"}))
+		Expect(skipped).To(HaveLen(1))
+		Expect(os.IsPermission(skipped[0])).To(BeTrue())
+	})
+
+})